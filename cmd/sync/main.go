@@ -5,8 +5,12 @@ import (
 	"github.com/seek-oss/kpt-functions/pkg/log"
 	"github.com/seek-oss/kpt-functions/pkg/util"
 	"io/ioutil"
+	"math"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
@@ -26,16 +30,71 @@ import (
 )
 
 const (
-	logLevelFunctionArg     = "logLevel"
-	cacheDirFunctionArg     = "cacheDir"
-	keepCacheFunctionArg    = "keepCache"
+	logLevelFunctionArg      = "logLevel"
+	cacheDirFunctionArg      = "cacheDir"
+	keepCacheFunctionArg     = "keepCache"
+	cachePolicyFunctionArg   = "cachePolicy"
+	cacheMaxAgeFunctionArg   = "cacheMaxAge"
+	cacheMaxSizeFunctionArg  = "cacheMaxSize"
+	concurrencyFunctionArg   = "concurrency"
+	lockModeFunctionArg      = "lockMode"
+	pruneFunctionArg         = "prune"
+	shallowFunctionArg       = "shallow"
+	offlineFunctionArg       = "offline"
+	strictFunctionArg        = "strict"
+	warmCacheFunctionArg     = "warmCache"
+	sourceDirFunctionArg     = "sourceDir"
+	localPathBaseFunctionArg = "localPathBase"
+
+	timeoutFunctionArg           = "timeout"
+	repositoryTimeoutFunctionArg = "repositoryTimeout"
+	retriesFunctionArg           = "retries"
+	retryBackoffFunctionArg      = "retryBackoff"
+
 	authMethodFunctionArg   = "authMethod"
 	gitKeySecretFunctionArg = "gitKeySecretID"
 	gitKeyFileFunctionArg   = "gitKeyFile"
-
-	defaultLogLevel   = zerolog.InfoLevel
-	defaultKeepCache  = false
-	defaultGitKeyFile = "~/.ssh/id_rsa"
+	gitUsernameFunctionArg  = "gitUsername"
+
+	gitCredentialFileFunctionArg        = "gitCredentialFile"
+	gitCredentialEnvVarFunctionArg      = "gitCredentialEnvVar"
+	gitCredentialSecretIDFunctionArg    = "gitCredentialSecretID"
+	gitKeyPassphraseFileFunctionArg     = "gitKeyPassphraseFile"
+	gitKeyPassphraseEnvVarFunctionArg   = "gitKeyPassphraseEnvVar"
+	gitKeyPassphraseSecretIDFunctionArg = "gitKeyPassphraseSecretID"
+
+	credentialsFunctionArg = "credentials"
+
+	urlRewritesFunctionArg = "urlRewrites"
+	mirrorsFunctionArg     = "mirrors"
+
+	knownHostsFileFunctionArg        = "knownHostsFile"
+	hostKeyFingerprintsFunctionArg   = "hostKeyFingerprints"
+	insecureIgnoreHostKeyFunctionArg = "insecureIgnoreHostKey"
+
+	// credentialsPrefixKey and credentialsHostKey are the keys of credentials entries that specify which
+	// repositories the entry applies to.
+	credentialsPrefixKey = "prefix"
+	credentialsHostKey   = "host"
+
+	defaultLogLevel    = zerolog.InfoLevel
+	defaultKeepCache   = false
+	defaultConcurrency = 1
+	defaultPrune       = false
+	defaultShallow     = false
+	defaultOffline     = false
+	defaultStrict      = false
+	defaultWarmCache   = false
+	defaultInsecure    = false
+	defaultRetries     = 2
+	defaultBackoff     = time.Second
+	defaultGitKeyFile  = "~/.ssh/id_rsa"
+	// defaultTokenUsername is the username used with httpsToken auth. Git hosts such as GitHub accept
+	// any non-empty username alongside a token.
+	defaultTokenUsername = "git"
+
+	// hostKeyFingerprintPrefix is the prefix of the SHA256 host key fingerprints printed by ssh-keygen -l.
+	hostKeyFingerprintPrefix = "SHA256:"
 )
 
 // logger is the configured zerolog Logger instance.
@@ -51,6 +110,8 @@ func main() {
 
 // realMain executes the sync operation and returns any errors.
 func realMain() error {
+	filters.InstallLocalTransport()
+
 	proc := newProcessor()
 	rw, err := util.ReadWriter()
 	if err != nil {
@@ -69,48 +130,49 @@ func newProcessor() framework.ResourceListProcessor {
 		var err error
 		var ok bool
 
-		if authMethod, ok := cm.Data[authMethodFunctionArg]; ok {
-			switch filters.AuthMethod(authMethod) {
-			case filters.AuthMethodKeyFile:
-				f, ok := cm.Data[gitKeyFileFunctionArg]
-				if !ok {
-					f, err = homedir.Expand(defaultGitKeyFile)
-					if err != nil {
-						return nil, err
-					}
-					logger.Info().Msgf("No Git key specified - falling back to %s", f)
-				}
-
-				key, err := readGitPrivateKeyFile(f)
-				if err != nil {
-					return nil, err
-				}
-
-				delegate.GitPrivateKey = key
-				delegate.AuthMethod = filters.AuthMethodKeyFile
-			case filters.AuthMethodKeySecret:
-				if secretID, ok := cm.Data[gitKeySecretFunctionArg]; ok {
-					key, err := readGitPrivateKeySecret(secretID)
-					if err != nil {
-						return nil, err
-					}
-
-					delegate.GitPrivateKey = key
-					delegate.AuthMethod = filters.AuthMethodKeyFile
-				} else {
-					err = errors.Errorf("Auth method was %s but no %s argument was passed", filters.AuthMethodKeySecret, gitKeySecretFunctionArg)
-					return nil, err
-				}
-			case filters.AuthMethodSSHAgent:
-				delegate.AuthMethod = filters.AuthMethodSSHAgent
-			case filters.AuthMethodNone:
-				delegate.AuthMethod = filters.AuthMethodNone
-			default:
-				err = errors.Errorf("Auth method %s is invalid", authMethod)
+		delegate.GitCredentials, err = readGitCredentials(cm.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if v, ok := cm.Data[credentialsFunctionArg]; ok {
+			delegate.CredentialRules, err = readCredentialRules(v)
+			if err != nil {
 				return nil, err
 			}
-		} else {
-			delegate.AuthMethod = filters.AuthMethodNone
+		}
+
+		if v, ok := cm.Data[urlRewritesFunctionArg]; ok {
+			delegate.URLRewrites, err = readURLRewrites(urlRewritesFunctionArg, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if v, ok := cm.Data[mirrorsFunctionArg]; ok {
+			delegate.Mirrors, err = readURLRewrites(mirrorsFunctionArg, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if v, ok := cm.Data[knownHostsFileFunctionArg]; ok {
+			delegate.KnownHostsFiles = []string{v}
+		}
+
+		if v, ok := cm.Data[hostKeyFingerprintsFunctionArg]; ok {
+			delegate.HostKeyFingerprints, err = parseHostKeyFingerprints(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		delegate.InsecureIgnoreHostKey = defaultInsecure
+		if v, ok := cm.Data[insecureIgnoreHostKeyFunctionArg]; ok {
+			delegate.InsecureIgnoreHostKey, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse insecureIgnoreHostKey argument")
+			}
 		}
 
 		logLevel := defaultLogLevel
@@ -123,6 +185,144 @@ func newProcessor() framework.ResourceListProcessor {
 
 		zerolog.SetGlobalLevel(logLevel)
 
+		delegate.CachePolicy = filters.CachePolicyIfMissing
+		if v, ok := cm.Data[cachePolicyFunctionArg]; ok {
+			switch filters.CachePolicy(v) {
+			case filters.CachePolicyIfMissing, filters.CachePolicyAlways, filters.CachePolicyNever:
+				delegate.CachePolicy = filters.CachePolicy(v)
+			default:
+				return nil, errors.Errorf("Cache policy %s is invalid", v)
+			}
+		}
+
+		delegate.LockMode = filters.LockModeNone
+		if v, ok := cm.Data[lockModeFunctionArg]; ok {
+			switch filters.LockMode(v) {
+			case filters.LockModeNone, filters.LockModeUpdate, filters.LockModeVerify:
+				delegate.LockMode = filters.LockMode(v)
+			default:
+				return nil, errors.Errorf("Lock mode %s is invalid", v)
+			}
+		}
+
+		delegate.SourceDir = cm.Data[sourceDirFunctionArg]
+
+		delegate.LocalPathBase = filters.LocalPathBaseClusterPackages
+		if v, ok := cm.Data[localPathBaseFunctionArg]; ok {
+			switch filters.LocalPathBase(v) {
+			case filters.LocalPathBaseClusterPackages, filters.LocalPathBaseWorkdir:
+				delegate.LocalPathBase = filters.LocalPathBase(v)
+			default:
+				return nil, errors.Errorf("Local path base %s is invalid", v)
+			}
+		}
+
+		delegate.Prune = defaultPrune
+		if v, ok := cm.Data[pruneFunctionArg]; ok {
+			delegate.Prune, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse prune argument")
+			}
+		}
+
+		delegate.Shallow = defaultShallow
+		if v, ok := cm.Data[shallowFunctionArg]; ok {
+			delegate.Shallow, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse shallow argument")
+			}
+		}
+
+		if v, ok := cm.Data[cacheMaxAgeFunctionArg]; ok {
+			delegate.CacheMaxAge, err = readDuration(cacheMaxAgeFunctionArg, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if v, ok := cm.Data[cacheMaxSizeFunctionArg]; ok {
+			delegate.CacheMaxSize, err = readSize(cacheMaxSizeFunctionArg, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		delegate.Offline = defaultOffline
+		if v, ok := cm.Data[offlineFunctionArg]; ok {
+			delegate.Offline, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse offline argument")
+			}
+		}
+
+		delegate.Strict = defaultStrict
+		if v, ok := cm.Data[strictFunctionArg]; ok {
+			delegate.Strict, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse strict argument")
+			}
+		}
+
+		delegate.WarmCache = defaultWarmCache
+		if v, ok := cm.Data[warmCacheFunctionArg]; ok {
+			delegate.WarmCache, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse warmCache argument")
+			}
+		}
+
+		if delegate.Offline && delegate.WarmCache {
+			return nil, errors.Errorf("Arguments %s and %s cannot both be true", offlineFunctionArg, warmCacheFunctionArg)
+		}
+		if _, ok := cm.Data[cacheDirFunctionArg]; (delegate.Offline || delegate.WarmCache) && !ok {
+			return nil, errors.Errorf("Arguments %s and %s require a %s argument", offlineFunctionArg,
+				warmCacheFunctionArg, cacheDirFunctionArg)
+		}
+
+		delegate.Concurrency = defaultConcurrency
+		if v, ok := cm.Data[concurrencyFunctionArg]; ok {
+			delegate.Concurrency, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse concurrency argument")
+			}
+			if delegate.Concurrency < 1 {
+				return nil, errors.Errorf("Concurrency %d is invalid, must be at least 1", delegate.Concurrency)
+			}
+		}
+
+		if v, ok := cm.Data[timeoutFunctionArg]; ok {
+			delegate.Timeout, err = readDuration(timeoutFunctionArg, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if v, ok := cm.Data[repositoryTimeoutFunctionArg]; ok {
+			delegate.RepositoryTimeout, err = readDuration(repositoryTimeoutFunctionArg, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		delegate.Retries = defaultRetries
+		if v, ok := cm.Data[retriesFunctionArg]; ok {
+			delegate.Retries, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not parse retries argument")
+			}
+			if delegate.Retries < 0 {
+				return nil, errors.Errorf("Retries %d is invalid, must not be negative", delegate.Retries)
+			}
+		}
+
+		delegate.RetryBackoff = defaultBackoff
+		if v, ok := cm.Data[retryBackoffFunctionArg]; ok {
+			delegate.RetryBackoff, err = readDuration(retryBackoffFunctionArg, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		keepCache := defaultKeepCache
 
 		delegate.CacheDir, ok = cm.Data[cacheDirFunctionArg]
@@ -142,6 +342,12 @@ func newProcessor() framework.ResourceListProcessor {
 			}
 		}
 
+		// Offline mode reads from, and warm cache mode writes to, a cache that must outlive the function.
+		if (delegate.Offline || delegate.WarmCache) && !keepCache {
+			return nil, errors.Errorf("Arguments %s and %s cannot be used with %s=false", offlineFunctionArg,
+				warmCacheFunctionArg, keepCacheFunctionArg)
+		}
+
 		defer func() {
 			if keepCache {
 				return
@@ -158,8 +364,279 @@ func newProcessor() framework.ResourceListProcessor {
 	return framework.SimpleProcessor{Config: &cm, Filter: filter}
 }
 
-// readGitPrivateKeySecret reads the Git private key file from AWS Secrets Manager.
-func readGitPrivateKeySecret(secretID string) ([]byte, error) {
+// readDuration parses the value of the specified duration argument, such as 30s or 5m, which must be positive.
+func readDuration(arg, v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.WrapPrefixf(err, "could not parse %s argument", arg)
+	}
+	if d <= 0 {
+		return 0, errors.Errorf("%s %s is invalid, must be positive", arg, v)
+	}
+
+	return d, nil
+}
+
+// sizeSuffixes defines the multipliers of the suffixes accepted by readSize, which match those of Kubernetes
+// resource quantities.
+var sizeSuffixes = map[string]int64{
+	"":   1,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+// readSize parses the value of the specified size argument, which is a positive number of bytes with an optional
+// decimal or binary suffix such as 500M or 10Gi.
+func readSize(arg, v string) (int64, error) {
+	digits := strings.TrimRightFunc(v, unicode.IsLetter)
+	multiplier, ok := sizeSuffixes[v[len(digits):]]
+	if !ok {
+		return 0, errors.Errorf("%s %s is invalid, unknown suffix %s", arg, v, v[len(digits):])
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, errors.WrapPrefixf(err, "could not parse %s argument", arg)
+	}
+	if n <= 0 || n > math.MaxInt64/multiplier {
+		return 0, errors.Errorf("%s %s is invalid, must be positive and at most %d bytes", arg, v, int64(math.MaxInt64))
+	}
+
+	return n * multiplier, nil
+}
+
+// readGitCredentials reads the Git credentials specified by the authMethod argument and its related arguments.
+func readGitCredentials(args map[string]string) (filters.GitCredentials, error) {
+	var creds filters.GitCredentials
+	var err error
+
+	authMethod, ok := args[authMethodFunctionArg]
+	if !ok {
+		creds.AuthMethod = filters.AuthMethodNone
+		return creds, nil
+	}
+
+	switch filters.AuthMethod(authMethod) {
+	case filters.AuthMethodKeyFile:
+		f, ok := args[gitKeyFileFunctionArg]
+		if !ok {
+			f, err = homedir.Expand(defaultGitKeyFile)
+			if err != nil {
+				return creds, err
+			}
+			logger.Info().Msgf("No Git key specified - falling back to %s", f)
+		}
+
+		creds.GitPrivateKey, err = readGitPrivateKeyFile(f)
+		if err != nil {
+			return creds, err
+		}
+
+		creds.GitKeyPassphrase, err = readGitKeyPassphrase(filters.AuthMethod(authMethod), args)
+		if err != nil {
+			return creds, err
+		}
+
+		creds.AuthMethod = filters.AuthMethodKeyFile
+	case filters.AuthMethodKeySecret:
+		secretID, ok := args[gitKeySecretFunctionArg]
+		if !ok {
+			return creds, errors.Errorf("Auth method was %s but no %s argument was passed", filters.AuthMethodKeySecret, gitKeySecretFunctionArg)
+		}
+
+		creds.GitPrivateKey, err = readSecret(secretID)
+		if err != nil {
+			return creds, err
+		}
+
+		creds.GitKeyPassphrase, err = readGitKeyPassphrase(filters.AuthMethod(authMethod), args)
+		if err != nil {
+			return creds, err
+		}
+
+		creds.AuthMethod = filters.AuthMethodKeyFile
+	case filters.AuthMethodSSHAgent:
+		creds.AuthMethod = filters.AuthMethodSSHAgent
+	case filters.AuthMethodHTTPSToken, filters.AuthMethodBasicAuth:
+		username, ok := args[gitUsernameFunctionArg]
+		if !ok {
+			if filters.AuthMethod(authMethod) == filters.AuthMethodBasicAuth {
+				return creds, errors.Errorf("Auth method was %s but no %s argument was passed", authMethod, gitUsernameFunctionArg)
+			}
+			username = defaultTokenUsername
+		}
+
+		password, err := readCredential(filters.AuthMethod(authMethod), args, gitCredentialArgs, true)
+		if err != nil {
+			return creds, err
+		}
+
+		creds.GitUsername = username
+		creds.GitPassword = strings.TrimSpace(password)
+		creds.AuthMethod = filters.AuthMethod(authMethod)
+	case filters.AuthMethodNone:
+		creds.AuthMethod = filters.AuthMethodNone
+	default:
+		return creds, errors.Errorf("Auth method %s is invalid", authMethod)
+	}
+
+	return creds, nil
+}
+
+// readCredentialRules parses the credentials argument, which is a YAML list of entries that each specify either a
+// prefix or a host, together with the same authentication arguments that are accepted by the function.
+func readCredentialRules(v string) ([]filters.CredentialRule, error) {
+	var entries []map[string]string
+	if err := kyaml.Unmarshal([]byte(v), &entries); err != nil {
+		return nil, errors.WrapPrefixf(err, "could not parse %s argument", credentialsFunctionArg)
+	}
+
+	rules := make([]filters.CredentialRule, len(entries))
+	for i, entry := range entries {
+		rule := &rules[i]
+		rule.Prefix = entry[credentialsPrefixKey]
+		rule.Host = entry[credentialsHostKey]
+		if (rule.Prefix == "") == (rule.Host == "") {
+			return nil, errors.Errorf("%s entry %d must specify exactly one of %s or %s",
+				credentialsFunctionArg, i, credentialsPrefixKey, credentialsHostKey)
+		}
+
+		creds, err := readGitCredentials(entry)
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "could not read %s entry %d", credentialsFunctionArg, i)
+		}
+		rule.Credentials = creds
+	}
+
+	return rules, nil
+}
+
+// readURLRewrites parses the specified argument, which is a YAML list of entries that each specify an insteadOf
+// prefix and the base that replaces it.
+func readURLRewrites(arg, v string) ([]filters.URLRewrite, error) {
+	var rewrites []filters.URLRewrite
+	if err := kyaml.Unmarshal([]byte(v), &rewrites); err != nil {
+		return nil, errors.WrapPrefixf(err, "could not parse %s argument", arg)
+	}
+
+	for i, r := range rewrites {
+		if r.InsteadOf == "" || r.Base == "" {
+			return nil, errors.Errorf("%s entry %d must specify both insteadOf and base", arg, i)
+		}
+	}
+
+	return rewrites, nil
+}
+
+// parseHostKeyFingerprints parses a list of host=fingerprint pairs separated by commas or whitespace. A host may be
+// listed more than once to accept multiple host keys.
+func parseHostKeyFingerprints(v string) (map[string][]string, error) {
+	fingerprints := map[string][]string{}
+	for _, pair := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		i := strings.Index(pair, "=")
+		if i <= 0 || !strings.HasPrefix(pair[i+1:], hostKeyFingerprintPrefix) {
+			return nil, errors.Errorf("Host key fingerprint %s is invalid, expected <host>=%s<fingerprint>", pair, hostKeyFingerprintPrefix)
+		}
+
+		host := pair[:i]
+		fingerprints[host] = append(fingerprints[host], pair[i+1:])
+	}
+
+	return fingerprints, nil
+}
+
+// credentialArgs defines the function arguments that a credential can be read from.
+type credentialArgs struct {
+	// description describes the credential in errors.
+	description string
+	// file is the argument that specifies a file containing the credential.
+	file string
+	// envVar is the argument that specifies the name of an environment variable containing the credential.
+	envVar string
+	// secretID is the argument that specifies the ID of an AWS Secrets Manager secret containing the credential.
+	secretID string
+}
+
+var (
+	// gitCredentialArgs defines the arguments for the token or password used for HTTPS authentication.
+	gitCredentialArgs = credentialArgs{
+		description: "Git credential",
+		file:        gitCredentialFileFunctionArg,
+		envVar:      gitCredentialEnvVarFunctionArg,
+		secretID:    gitCredentialSecretIDFunctionArg,
+	}
+	// gitKeyPassphraseArgs defines the arguments for the passphrase of an encrypted SSH private key.
+	gitKeyPassphraseArgs = credentialArgs{
+		description: "Git key passphrase",
+		file:        gitKeyPassphraseFileFunctionArg,
+		envVar:      gitKeyPassphraseEnvVarFunctionArg,
+		secretID:    gitKeyPassphraseSecretIDFunctionArg,
+	}
+)
+
+// readCredential reads a credential from a file, an environment variable or AWS Secrets Manager, as specified by the
+// function arguments. If required is true then exactly one of the arguments must be passed, otherwise at most one
+// may be passed and an empty credential is returned if none are.
+func readCredential(authMethod filters.AuthMethod, args map[string]string, source credentialArgs, required bool) (string, error) {
+	var passed []string
+	for _, arg := range []string{source.file, source.envVar, source.secretID} {
+		if _, ok := args[arg]; ok {
+			passed = append(passed, arg)
+		}
+	}
+
+	switch {
+	case required && len(passed) != 1:
+		return "", errors.Errorf("Auth method was %s but %d of the %s, %s and %s arguments were passed, expected exactly one",
+			authMethod, len(passed), source.file, source.envVar, source.secretID)
+	case len(passed) > 1:
+		return "", errors.Errorf("Auth method was %s but %d of the %s, %s and %s arguments were passed, expected at most one",
+			authMethod, len(passed), source.file, source.envVar, source.secretID)
+	case len(passed) == 0:
+		return "", nil
+	}
+
+	var credential []byte
+	var err error
+	switch passed[0] {
+	case source.file:
+		credential, err = ioutil.ReadFile(args[source.file])
+	case source.envVar:
+		name := args[source.envVar]
+		if v := os.Getenv(name); v != "" {
+			credential = []byte(v)
+		} else {
+			err = errors.Errorf("Env variable %s must be defined to use %s auth", name, authMethod)
+		}
+	case source.secretID:
+		credential, err = readSecret(args[source.secretID])
+	}
+	if err != nil {
+		return "", errors.WrapPrefixf(err, "could not read %s", source.description)
+	}
+
+	return string(credential), nil
+}
+
+// readGitKeyPassphrase reads the optional passphrase of an encrypted Git private key. Trailing newlines are removed
+// so that passphrases can be read from files.
+func readGitKeyPassphrase(authMethod filters.AuthMethod, args map[string]string) (string, error) {
+	passphrase, err := readCredential(authMethod, args, gitKeyPassphraseArgs, false)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(passphrase, "\r\n"), nil
+}
+
+// readSecret reads a secret, such as a Git private key file or token, from AWS Secrets Manager.
+func readSecret(secretID string) ([]byte, error) {
 	sess, err := session.NewSession()
 	if err != nil {
 		return nil, err
@@ -8,6 +8,7 @@ require (
 	github.com/Masterminds/semver v1.5.0 // indirect
 	github.com/Masterminds/sprig v2.22.0+incompatible
 	github.com/aws/aws-sdk-go v1.38.12
+	github.com/evanphx/json-patch v4.9.0+incompatible
 	github.com/go-errors/errors v1.1.1
 	github.com/go-git/go-git/v5 v5.3.0
 	github.com/go-openapi/spec v0.19.5
@@ -16,6 +17,7 @@ require (
 	github.com/mitchellh/copystructure v1.1.1 // indirect
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/rs/zerolog v1.21.0
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	k8s.io/api v0.20.4
 	sigs.k8s.io/kustomize/cmd/config v0.9.7
 	sigs.k8s.io/kustomize/kyaml v0.10.15
@@ -0,0 +1,262 @@
+package filters
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// applySettersFunctionName defines the name of the image of the kpt v1 function that applies setters.
+	applySettersFunctionName = "apply-setters"
+	// kptSetCommentPrefix defines the prefix of the comments that mark fields that are set by apply-setters.
+	kptSetCommentPrefix = "kpt-set:"
+)
+
+// setterReferencePattern matches the references to setters in the pattern of a kpt-set comment, such as ${image}.
+var setterReferencePattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// applySettersConfigs returns the data of the configs of the apply-setters functions in the pipeline of the kpt v1
+// Kptfile of the specified subpackage, in order. A config is either specified inline by the configMap field of the
+// function or by a ConfigMap resource in the file that its configPath refers to, relative to the Kptfile.
+func applySettersConfigs(p *subpackage) ([]*yaml.RNode, error) {
+	mutators, err := p.kptfile.Pipe(yaml.Lookup("pipeline", "mutators"))
+	if err != nil || mutators == nil {
+		return nil, err
+	}
+
+	functions, err := mutators.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []*yaml.RNode
+	for _, fn := range functions {
+		image, err := fn.Pipe(yaml.Lookup("image"))
+		if err != nil {
+			return nil, err
+		}
+		if image == nil || !isApplySettersImage(image.YNode().Value) {
+			continue
+		}
+
+		config, err := fn.Pipe(yaml.Lookup("configMap"))
+		if err != nil {
+			return nil, err
+		}
+		if config != nil {
+			configs = append(configs, config)
+			continue
+		}
+
+		configPath, err := fn.Pipe(yaml.Lookup("configPath"))
+		if err != nil {
+			return nil, err
+		}
+		if configPath == nil {
+			continue
+		}
+
+		config, err = configPathData(p, configPath.YNode().Value)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+// configPathData returns the data of the ConfigMap resource of the specified subpackage in the file at the specified
+// path relative to its Kptfile.
+func configPathData(p *subpackage, configPath string) (*yaml.RNode, error) {
+	target := path.Join(p.dir, configPath)
+	for _, node := range p.resources {
+		meta, err := node.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(meta.Annotations[kioutil.PathAnnotation]) != target {
+			continue
+		}
+
+		return node.Pipe(yaml.LookupCreate(yaml.MappingNode, "data"))
+	}
+
+	return nil, errors.Errorf("apply-setters config %s of the Kptfile in %s was not found", configPath, p.dir)
+}
+
+// isApplySettersImage returns whether the specified function image is a version of apply-setters.
+func isApplySettersImage(image string) bool {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return path.Base(image) == applySettersFunctionName
+}
+
+// applySettersValues returns the values of the setters in the specified apply-setters configs. Values in later
+// configs take precedence.
+func applySettersValues(configs []*yaml.RNode) (map[string]string, error) {
+	values := map[string]string{}
+	for _, config := range configs {
+		err := config.VisitFields(func(node *yaml.MapNode) error {
+			values[node.Key.YNode().Value] = node.Value.YNode().Value
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// setApplySetter sets the value of the setter with the specified name in each of the specified apply-setters configs
+// that declares it, and returns whether any of them did. List values are set as a YAML flow sequence.
+func setApplySetter(configs []*yaml.RNode, name, value string, listValues []string) (bool, error) {
+	if len(listValues) > 0 {
+		list := yaml.NewListRNode(listValues...)
+		list.YNode().Style = yaml.FlowStyle
+		s, err := list.String()
+		if err != nil {
+			return false, err
+		}
+		value = strings.TrimSpace(s)
+	}
+
+	var found bool
+	for _, config := range configs {
+		if config.Field(name) == nil {
+			continue
+		}
+
+		if err := config.PipeE(yaml.SetField(name, yaml.NewStringRNode(value))); err != nil {
+			return false, err
+		}
+		found = true
+	}
+
+	return found, nil
+}
+
+// applySetters sets the fields of the specified resource nodes that are marked by kpt-set comments to the values of
+// the setters that their patterns refer to, in the same way as the apply-setters function. Fields whose patterns
+// refer to setters without values are left unchanged.
+func applySetters(nodes []*yaml.RNode, values map[string]string) error {
+	for _, node := range nodes {
+		if err := applySettersToNode(node.YNode(), values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applySettersToNode recursively applies setters to the fields of the specified node.
+func applySettersToNode(node *yaml.Node, values map[string]string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := applySettersToNode(child, values); err != nil {
+				return err
+			}
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			switch value.Kind {
+			case yaml.SequenceNode:
+				if err := setSequence(key.Value, value, kptSetPattern(key.LineComment), values); err != nil {
+					return err
+				}
+			case yaml.ScalarNode:
+				setScalar(value, kptSetPattern(value.LineComment), values)
+			}
+
+			if err := applySettersToNode(value, values); err != nil {
+				return err
+			}
+		}
+
+	case yaml.SequenceNode:
+		for _, element := range node.Content {
+			if element.Kind == yaml.ScalarNode {
+				setScalar(element, kptSetPattern(element.LineComment), values)
+				continue
+			}
+
+			if err := applySettersToNode(element, values); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// kptSetPattern returns the pattern of the specified kpt-set comment, or an empty string if it is not one.
+func kptSetPattern(comment string) string {
+	comment = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(comment), "#"))
+	if !strings.HasPrefix(comment, kptSetCommentPrefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(comment, kptSetCommentPrefix))
+}
+
+// setScalar sets the specified scalar node to the specified pattern with its setter references substituted.
+func setScalar(node *yaml.Node, pattern string, values map[string]string) {
+	if pattern == "" {
+		return
+	}
+
+	missing := false
+	value := setterReferencePattern.ReplaceAllStringFunc(pattern, func(ref string) string {
+		v, ok := values[setterReferencePattern.FindStringSubmatch(ref)[1]]
+		missing = missing || !ok
+		return v
+	})
+	if missing {
+		return
+	}
+
+	node.Value = value
+	// Clear the tag so that the type is inferred from the new value. The quoting style of the node is preserved.
+	node.Tag = ""
+}
+
+// setSequence replaces the elements of the specified sequence node, which is the value of the field with the
+// specified name, with the elements of the list value of the setter that the specified pattern refers to.
+func setSequence(field string, node *yaml.Node, pattern string, values map[string]string) error {
+	if pattern == "" {
+		return nil
+	}
+
+	refs := setterReferencePattern.FindStringSubmatch(pattern)
+	if refs == nil || refs[0] != pattern {
+		return errors.Errorf("kpt-set pattern %s of list field %s must refer to a single setter", pattern, field)
+	}
+
+	value, ok := values[refs[1]]
+	if !ok {
+		return nil
+	}
+
+	list, err := yaml.Parse(value)
+	if err != nil || list.YNode().Kind != yaml.SequenceNode {
+		return errors.Errorf("value %q of setter %s of list field %s is not a list", value, refs[1], field)
+	}
+
+	node.Content = list.YNode().Content
+	return nil
+}
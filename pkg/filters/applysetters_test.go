@@ -0,0 +1,102 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestIsApplySettersImage(t *testing.T) {
+	var tests = []struct {
+		image    string
+		expected bool
+	}{
+		{image: "gcr.io/kpt-fn/apply-setters:v0.2", expected: true},
+		{image: "gcr.io/kpt-fn/apply-setters", expected: true},
+		{image: "localhost:5000/apply-setters@sha256:abc", expected: true},
+		{image: "gcr.io/kpt-fn/set-namespace:v0.1", expected: false},
+		{image: "localhost:5000/apply-setters-fork:v1", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			if actual := isApplySettersImage(test.image); actual != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestApplySetters(t *testing.T) {
+	var tests = []struct {
+		name     string
+		input    string
+		values   map[string]string
+		expected string
+		err      string
+	}{
+		{
+			name:     "scalar",
+			input:    "replicas: 1 # kpt-set: ${replicas}\n",
+			values:   map[string]string{"replicas": "3"},
+			expected: "replicas: 3 # kpt-set: ${replicas}\n",
+		},
+		{
+			name:     "pattern",
+			input:    "image: app:1.0.0 # kpt-set: ${name}:${tag}\n",
+			values:   map[string]string{"name": "worker", "tag": "2.0.0"},
+			expected: "image: worker:2.0.0 # kpt-set: ${name}:${tag}\n",
+		},
+		{
+			name:     "missing setter",
+			input:    "image: app:1.0.0 # kpt-set: ${name}:${tag}\n",
+			values:   map[string]string{"tag": "2.0.0"},
+			expected: "image: app:1.0.0 # kpt-set: ${name}:${tag}\n",
+		},
+		{
+			name:     "quoted",
+			input:    "enabled: \"false\" # kpt-set: ${enabled}\n",
+			values:   map[string]string{"enabled": "true"},
+			expected: "enabled: \"true\" # kpt-set: ${enabled}\n",
+		},
+		{
+			name:     "list",
+			input:    "hosts: # kpt-set: ${hosts}\n- a\nports:\n- 80 # kpt-set: ${port}\n",
+			values:   map[string]string{"hosts": "[b, c]", "port": "8080"},
+			expected: "hosts: # kpt-set: ${hosts}\n- b\n- c\nports:\n- 8080 # kpt-set: ${port}\n",
+		},
+		{
+			name:   "list pattern",
+			input:  "hosts: # kpt-set: ${prefix}-${hosts}\n- a\n",
+			values: map[string]string{"hosts": "[b]"},
+			err:    "kpt-set pattern ${prefix}-${hosts} of list field hosts must refer to a single setter",
+		},
+		{
+			name:   "list value",
+			input:  "hosts: # kpt-set: ${hosts}\n- a\n",
+			values: map[string]string{"hosts": "b"},
+			err:    `value "b" of setter hosts of list field hosts is not a list`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := yaml.MustParse(test.input)
+			err := applySetters([]*yaml.RNode{node}, test.values)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := node.MustString(); actual != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, actual)
+			}
+		})
+	}
+}
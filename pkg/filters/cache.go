@@ -0,0 +1,210 @@
+package filters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
+
+// repositoryLockSuffix defines the suffix that is appended to the directory of a cached repository to form the name
+// of the file that is used to lock it across processes. Lock files are never removed, because removing a lock file
+// that another process is waiting on would allow two processes to hold the lock at the same time.
+const repositoryLockSuffix = ".lock"
+
+// cacheEntryPattern matches the names of the directories of cached repositories, as returned by repositoryDir and
+// shallowRepositoryDir.
+var cacheEntryPattern = regexp.MustCompile(`^[0-9a-f]{64}(-shallow-[0-9a-f]{16})?$`)
+
+// lockRepository acquires the lock that guards the cached repository in the specified directory, and returns a
+// function that releases it. The lock must be held exclusively while the repository is cloned or fetched, and shared
+// while objects are read from it. It guards the repository both against other goroutines and against other
+// processes that share the CacheDir. Acquiring the lock records that the repository was used.
+func (f *ClusterPackagesFilter) lockRepository(repoDir string, exclusive bool) (func(), error) {
+	mutex := f.repositoryLock(repoDir)
+	if exclusive {
+		mutex.Lock()
+	} else {
+		mutex.RLock()
+	}
+	unlockMutex := func() {
+		if exclusive {
+			mutex.Unlock()
+		} else {
+			mutex.RUnlock()
+		}
+	}
+
+	file, err := openLockFile(repoDir + repositoryLockSuffix)
+	if err != nil {
+		unlockMutex()
+		return nil, err
+	}
+
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		unlockMutex()
+		return nil, errors.WrapPrefixf(err, "error locking %s", file.Name())
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(file.Name(), now, now); err != nil {
+		f.Logger.Warn().Err(err).Msgf("Could not record use of %s", repoDir)
+	}
+
+	return func() {
+		// Closing the file releases the lock.
+		if err := file.Close(); err != nil {
+			f.Logger.Warn().Err(err).Msgf("Could not unlock %s", file.Name())
+		}
+		unlockMutex()
+	}, nil
+}
+
+// openLockFile opens the specified lock file, creating it and its directory if they do not exist.
+func openLockFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.WrapPrefixf(err, "error creating directory for %s", path)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.WrapPrefixf(err, "error opening %s", path)
+	}
+
+	return file, nil
+}
+
+// cacheEntry describes a cached repository.
+type cacheEntry struct {
+	dir      string
+	lastUsed time.Time
+	size     int64
+}
+
+// collectGarbage removes the cached repositories that have not been used within the CacheMaxAge, and then the least
+// recently used cached repositories until the total size of the CacheDir is within the CacheMaxSize. Repositories
+// that are in use by another process are skipped.
+func (f *ClusterPackagesFilter) collectGarbage() error {
+	if f.CacheMaxAge <= 0 && f.CacheMaxSize <= 0 {
+		return nil
+	}
+
+	entries, err := f.cacheEntries()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		expired := f.CacheMaxAge > 0 && now.Sub(entry.lastUsed) > f.CacheMaxAge
+		oversized := f.CacheMaxSize > 0 && total > f.CacheMaxSize
+		if !expired && !oversized {
+			continue
+		}
+
+		removed, err := f.evict(entry.dir)
+		if err != nil {
+			return err
+		}
+		if removed {
+			f.Logger.Debug().Msgf("Removed %s from the cache, last used %s", entry.dir, entry.lastUsed.Format(time.RFC3339))
+			total -= entry.size
+		}
+	}
+
+	return nil
+}
+
+// cacheEntries returns the cached repositories in the CacheDir.
+func (f *ClusterPackagesFilter) cacheEntries() ([]cacheEntry, error) {
+	infos, err := ioutil.ReadDir(f.CacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WrapPrefixf(err, "error listing cache directory %s", f.CacheDir)
+	}
+
+	var entries []cacheEntry
+	for _, info := range infos {
+		if !info.IsDir() || !cacheEntryPattern.MatchString(info.Name()) {
+			continue
+		}
+
+		entry := cacheEntry{dir: filepath.Join(f.CacheDir, info.Name()), lastUsed: info.ModTime()}
+		if lockInfo, err := os.Stat(entry.dir + repositoryLockSuffix); err == nil {
+			entry.lastUsed = lockInfo.ModTime()
+		}
+
+		entry.size, err = directorySize(entry.dir)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// evict removes the cached repository in the specified directory, unless it is locked by another process. The
+// returned boolean is true if the repository was removed.
+func (f *ClusterPackagesFilter) evict(repoDir string) (bool, error) {
+	mutex := f.repositoryLock(repoDir)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	file, err := openLockFile(repoDir + repositoryLockSuffix)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	locked, err := tryLockFile(file)
+	if err != nil {
+		return false, errors.WrapPrefixf(err, "error locking %s", file.Name())
+	}
+	if !locked {
+		f.Logger.Debug().Msgf("Not removing %s from the cache as it is in use", repoDir)
+		return false, nil
+	}
+
+	if err := os.RemoveAll(repoDir); err != nil {
+		return false, errors.WrapPrefixf(err, "error removing %s from the cache", repoDir)
+	}
+
+	return true, nil
+}
+
+// directorySize returns the total size of the files under the specified directory.
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WrapPrefixf(err, "error measuring the size of %s", dir)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,180 @@
+package filters
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kpt/pkg/kptfile"
+	"github.com/rs/zerolog"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	now := time.Now()
+
+	// Each cached repository contains a single file of the specified size and was last used the specified time ago.
+	type cached struct {
+		repo string
+		size int
+		age  time.Duration
+	}
+	repositories := []cached{
+		{repo: "new", size: 100, age: time.Minute},
+		{repo: "recent", size: 100, age: time.Hour},
+		{repo: "old", size: 100, age: 48 * time.Hour},
+	}
+
+	var tests = []struct {
+		name     string
+		maxAge   time.Duration
+		maxSize  int64
+		inUse    string
+		expected []string
+	}{
+		{name: "no limits", expected: []string{"new", "old", "recent"}},
+		{name: "max age", maxAge: 24 * time.Hour, expected: []string{"new", "recent"}},
+		{name: "max size", maxSize: 150, expected: []string{"new"}},
+		{name: "max size and age", maxAge: 24 * time.Hour, maxSize: 200, expected: []string{"new", "recent"}},
+		{name: "in use", maxAge: 24 * time.Hour, inUse: "old", expected: []string{"new", "old", "recent"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &ClusterPackagesFilter{
+				CacheDir:     t.TempDir(),
+				CacheMaxAge:  test.maxAge,
+				CacheMaxSize: test.maxSize,
+				Logger:       zerolog.Nop(),
+			}
+
+			names := map[string]string{}
+			for _, r := range repositories {
+				repoDir := f.repositoryDir(r.repo)
+				names[filepath.Base(repoDir)] = r.repo
+
+				if err := os.MkdirAll(repoDir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(repoDir, "objects"), make([]byte, r.size), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(repoDir+repositoryLockSuffix, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(repoDir+repositoryLockSuffix, now.Add(-r.age), now.Add(-r.age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Simulate another process using the repository by locking it through a separate file descriptor.
+			if test.inUse != "" {
+				if runtime.GOOS == "windows" {
+					t.Skip("advisory file locks are not supported on Windows")
+				}
+
+				file, err := openLockFile(f.repositoryDir(test.inUse) + repositoryLockSuffix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				if err := lockFile(file, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := f.collectGarbage(); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := f.cacheEntries()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var actual []string
+			for _, entry := range entries {
+				actual = append(actual, names[filepath.Base(entry.dir)])
+			}
+			sort.Strings(actual)
+
+			if strings.Join(actual, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestResolvePackageLocking(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("advisory file locks are not supported on Windows")
+	}
+
+	upstreamDir, _, _ := newTestRepository(t, 1)
+	f := &ClusterPackagesFilter{CacheDir: t.TempDir(), Logger: zerolog.Nop()}
+	pkg := &Package{Git: kptfile.Git{Repo: upstreamDir, Ref: "master"}}
+
+	// evictable returns whether another process could evict the repository, by locking it through a separate file
+	// descriptor.
+	evictable := func() bool {
+		file, err := openLockFile(f.repositoryDir(upstreamDir) + repositoryLockSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		locked, err := tryLockFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locked
+	}
+
+	// The repository is cloned under an exclusive lock, which is held until it is released by the caller.
+	_, _, unlock, err := f.resolvePackage(context.Background(), pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evictable() {
+		t.Fatal("expected the repository to be locked after cloning it")
+	}
+	unlock()
+
+	// Once cached, packages from the repository are resolved concurrently under shared locks.
+	_, _, unlock, err = f.resolvePackage(context.Background(), pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := make(chan error, 1)
+	go func() {
+		_, _, unlock, err := f.resolvePackage(context.Background(), pkg)
+		if err == nil {
+			unlock()
+		}
+		resolved <- err
+	}()
+
+	select {
+	case err := <-resolved:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the cached repository to be resolved while it is locked by another package")
+	}
+
+	if evictable() {
+		t.Fatal("expected the repository to be locked until the package is materialised")
+	}
+	unlock()
+
+	if !evictable() {
+		t.Fatal("expected the repository to be unlocked")
+	}
+}
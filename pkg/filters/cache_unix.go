@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package filters
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquires an advisory lock on the specified file, waiting until it is available.
+func lockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// tryLockFile acquires an exclusive advisory lock on the specified file if it is available. The returned boolean is
+// false if the file is locked by another process.
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
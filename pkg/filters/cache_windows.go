@@ -0,0 +1,14 @@
+package filters
+
+import "os"
+
+// lockFile does nothing, as advisory file locks are not supported on Windows. Cached repositories are only guarded
+// against concurrent use within a single process.
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+// tryLockFile always succeeds, as advisory file locks are not supported on Windows.
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
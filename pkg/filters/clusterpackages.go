@@ -2,13 +2,13 @@ package filters
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"net/url"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/GoogleContainerTools/kpt/pkg/kptfile"
 	"github.com/go-git/go-git/v5"
@@ -16,6 +16,7 @@ import (
 	"github.com/rs/zerolog"
 	"sigs.k8s.io/kustomize/kyaml/errors"
 	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
@@ -62,11 +63,19 @@ type ClusterPackagesSpec struct {
 	Variables []Variable `yaml:"variables,omitempty"`
 	// Packages specifies the list of Kpt packages that are installed by this cluster.
 	Packages []Package `yaml:"packages,omitempty"`
+	// Files specifies globs of additional files, such as READMEs or JSON dashboards, that are included from every
+	// package as PackageFile resources. Globs without a slash match file names, and globs with a slash match paths
+	// relative to the package.
+	Files []string `yaml:"files,omitempty"`
+	// Pipeline specifies filters that are run, in order, on the resources of each package that does not specify its
+	// own Package.Pipeline. Each package is processed in isolation from the others.
+	Pipeline []PipelineFunction `yaml:"pipeline,omitempty"`
 }
 
 // LocalPackage defines a local Kpt package location.
 type LocalPackage struct {
-	// Directory specifies the relative location of the Kpt package
+	// Directory specifies the location of the Kpt package, relative to the directory of the file that declares the
+	// ClusterPackages resource unless the filter is configured to use LocalPathBaseWorkdir.
 	Directory string `yaml:"directory"`
 }
 
@@ -82,6 +91,19 @@ type Package struct {
 	// Variables specifies the list of package-level variable definitions. In the case that a package has a setter
 	// whose value is specified by both cluster-level and package-level variables, the package-level value will be used.
 	Variables []Variable `yaml:"variables,omitempty"`
+	// Files specifies globs of additional files that are included from this package as PackageFile resources, in
+	// addition to those specified by ClusterPackagesSpec.Files.
+	Files []string `yaml:"files,omitempty"`
+	// Include specifies the files and resources of the package that are rendered. Defaults to all of them.
+	Include PackageSelector `yaml:"include,omitempty"`
+	// Exclude specifies files and resources of the package that are not rendered, even if they are included.
+	Exclude PackageSelector `yaml:"exclude,omitempty"`
+	// Patches specifies strategic merge and JSON6902 patches that are applied, in order, to the resources of the
+	// package after its setters have been set and its templates have been rendered.
+	Patches []Patch `yaml:"patches,omitempty"`
+	// Pipeline specifies filters that are run, in order, on the resources of the package after its patches have been
+	// applied. Overrides ClusterPackagesSpec.Pipeline when specified, even if it is empty.
+	Pipeline []PipelineFunction `yaml:"pipeline,omitempty"`
 }
 
 // Variable defines the value for a Kpt package setter.
@@ -98,37 +120,149 @@ type Variable struct {
 type AuthMethod string
 
 const (
-	AuthMethodKeyFile   AuthMethod = "keyFile"
-	AuthMethodKeySecret AuthMethod = "keySecret"
-	AuthMethodSSHAgent  AuthMethod = "sshAgent"
-	AuthMethodNone      AuthMethod = "none"
+	AuthMethodKeyFile    AuthMethod = "keyFile"
+	AuthMethodKeySecret  AuthMethod = "keySecret"
+	AuthMethodSSHAgent   AuthMethod = "sshAgent"
+	AuthMethodHTTPSToken AuthMethod = "httpsToken"
+	AuthMethodBasicAuth  AuthMethod = "basicAuth"
+	AuthMethodNone       AuthMethod = "none"
 )
 
 // ClusterPackagesFilter defines a kio.Filter that processes ClusterPackages custom resources.
 type ClusterPackagesFilter struct {
 	// CacheDir specifies a directory that is used by the filter to cache Git repositories.
 	CacheDir string
-	// GitPrivateKey specifies the the private key to use for Git.
-	GitPrivateKey []byte
+	// GitCredentials specifies the default credentials used for Git repositories that do not match any of the
+	// CredentialRules.
+	GitCredentials
+	// CredentialRules specifies the credentials used for Git repositories that match a URL prefix or host.
+	CredentialRules []CredentialRule
+	// Shallow specifies that packages that refer to a branch, a tag or a full commit SHA are fetched from a shallow
+	// clone of only the most recent commit of that ref, which is cached separately for each ref. The full repository
+	// is cloned instead when a package refers to an abbreviated commit SHA, when the remote does not support shallow
+	// fetches, or when it does not allow commits to be fetched by their SHA.
+	Shallow bool
+	// URLRewrites specifies rewrites that are applied to repository URLs before they are cloned or fetched. The
+	// original repository URL is still used to identify the repository in the cache and in lock files.
+	URLRewrites []URLRewrite
+	// Mirrors specifies rewrites that produce fallback repository URLs, which are tried in order when cloning or
+	// fetching from the rewritten repository URL fails.
+	Mirrors []URLRewrite
+	// KnownHostsFiles specifies known_hosts files that are used to verify SSH host keys in addition to the default
+	// known_hosts files.
+	KnownHostsFiles []string
+	// HostKeyFingerprints specifies the SHA256 fingerprints of the SSH host keys that are accepted for each host,
+	// in the format printed by ssh-keygen -l. Pinned hosts are not verified against known_hosts files.
+	HostKeyFingerprints map[string][]string
+	// InsecureIgnoreHostKey specifies that SSH host keys are not verified. This should only be used in
+	// throwaway environments.
+	InsecureIgnoreHostKey bool
+	// CacheMaxAge specifies that cached Git repositories that have not been used for longer than this are removed
+	// after the packages are fetched. Defaults to no limit.
+	CacheMaxAge time.Duration
+	// CacheMaxSize specifies the maximum total size in bytes of the cached Git repositories. When it is exceeded, the
+	// least recently used repositories are removed after the packages are fetched. Defaults to no limit.
+	CacheMaxSize int64
 	// Logger specifies the logger to be used by the filter.
 	Logger zerolog.Logger
-	// AuthMethod specifies the method to use for authenticating to Git repositories
-	AuthMethod AuthMethod
+	// CachePolicy specifies when cached Git repositories are refreshed from their remotes. Defaults to
+	// CachePolicyIfMissing.
+	CachePolicy CachePolicy
+	// LockMode specifies how ClusterPackagesLock resources are emitted and verified. Defaults to LockModeNone.
+	LockMode LockMode
+	// Prune specifies whether previously rendered resources under the baseDir of each ClusterPackages resource are
+	// replaced by the newly rendered resources. This requires the ClusterPackagesInventory resources and the
+	// previously rendered resources to be included in the input. Pruning cannot be used with file globs, as the
+	// PackageFile resources would not be written as files.
+	Prune bool
+	// SourceDir specifies the directory that the config.kubernetes.io/path annotations of the input resources are
+	// relative to. Local packages must be inside this directory. Defaults to the working directory.
+	SourceDir string
+	// LocalPathBase specifies what the directories of local packages are relative to. Defaults to
+	// LocalPathBaseClusterPackages.
+	LocalPathBase LocalPathBase
+	// Offline specifies that Git repositories are never cloned or fetched, so every repository and ref must already
+	// be cached in the CacheDir. The filter fails before fetching any packages if any of them are missing. Local
+	// repositories are still cloned and fetched, as they do not require network access.
+	Offline bool
+	// Strict specifies that package-level variables that do not match any setter of their package are reported as
+	// errors rather than warnings.
+	Strict bool
+	// WarmCache specifies that every Git repository and ref referenced by the ClusterPackages resources is cloned
+	// or fetched into the CacheDir without rendering any packages. The input is returned unchanged.
+	WarmCache bool
+	// Timeout specifies the maximum time taken to fetch and process all packages. Defaults to no timeout.
+	Timeout time.Duration
+	// RepositoryTimeout specifies the maximum time taken by each attempt to clone or fetch a repository. Defaults to
+	// no timeout.
+	RepositoryTimeout time.Duration
+	// Retries specifies the number of times that a clone or fetch that fails with a transient error, such as a
+	// network failure or a timeout, is retried. Defaults to 0.
+	Retries int
+	// RetryBackoff specifies the delay before the first retry, which doubles after each retry. Defaults to one
+	// second.
+	RetryBackoff time.Duration
+	// Concurrency specifies the maximum number of packages that are fetched and processed at the same time.
+	// Defaults to 1.
+	Concurrency int
+
+	// repoLocks holds a *sync.RWMutex for each cached repository directory.
+	repoLocks sync.Map
 }
 
 // Filter implements kio.Filter.Filter.
 func (f *ClusterPackagesFilter) Filter(input []*yaml.RNode) ([]*yaml.RNode, error) {
 	ctx := context.Background()
-	var output []*yaml.RNode
-	for _, node := range input {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	if f.InsecureIgnoreHostKey {
+		f.Logger.Warn().Msgf("SSH host key verification is disabled, Git servers will not be authenticated")
+	}
+
+	// Unmarshal all of the ClusterPackages resources up-front so that the packages they define can be
+	// fetched concurrently.
+	resources := make([]*ClusterPackages, len(input))
+	locks := map[string]*ClusterPackagesLock{}
+	inventories := map[string]*ClusterPackagesInventory{}
+	// replaced maps the input indexes of existing locks and inventories to their keys. These are replaced by the
+	// locks and inventories emitted for the ClusterPackages resources, whose keys are recorded by emitted.
+	replaced := map[int]string{}
+	emitted := map[string]bool{}
+	var jobs []packageJob
+	for i, node := range input {
 		meta, err := node.GetMeta()
 		if err != nil {
 			return nil, err
 		}
 
-		// If the current resource isn't a ClusterPackages resource then forward it through.
+		if f.lockMode() != LockModeNone && isClusterPackagesLock(meta) {
+			lock := &ClusterPackagesLock{}
+			if err := yaml.Unmarshal([]byte(node.MustString()), lock); err != nil {
+				return nil, errors.WrapPrefixf(err, "could not unmarshal input")
+			}
+			key := lockKey(lock.Annotations[kioutil.PathAnnotation], lock.Name)
+			locks[key] = lock
+			replaced[i] = key
+			continue
+		}
+
+		if f.Prune && isClusterPackagesInventory(meta) {
+			inventory := &ClusterPackagesInventory{}
+			if err := yaml.Unmarshal([]byte(node.MustString()), inventory); err != nil {
+				return nil, errors.WrapPrefixf(err, "could not unmarshal input")
+			}
+			key := inventoryKey(inventory.Annotations[kioutil.PathAnnotation], inventory.Name)
+			inventories[key] = inventory
+			replaced[i] = key
+			continue
+		}
+
+		// If the current resource isn't a ClusterPackages resource then it will be forwarded through.
 		if meta.APIVersion != ClusterPackagesAPIVersion || meta.Kind != ClusterPackagesKind {
-			output = append(output, node)
 			continue
 		}
 
@@ -137,180 +271,454 @@ func (f *ClusterPackagesFilter) Filter(input []*yaml.RNode) ([]*yaml.RNode, erro
 		if err := yaml.Unmarshal([]byte(node.MustString()), res); err != nil {
 			return nil, errors.WrapPrefixf(err, "could not unmarshal input")
 		}
+		resources[i] = res
+		emitted[clusterPackagesLockKey(res)] = true
+		emitted[clusterPackagesInventoryKey(res)] = true
 
-		// Fetch and process all of the resources for all of the packages defined in the ClusterPackages spec.
-		newNodes, err := f.fetchClusterResources(ctx, res)
-		if err != nil {
+		for j := range res.Spec.Packages {
+			jobs = append(jobs, packageJob{resource: res, pkg: &res.Spec.Packages[j]})
+		}
+	}
+
+	// Check that no ClusterPackages resource would write outside of its own directories before doing anything else.
+	problems := validateClusterPackages(resources)
+	if f.Prune {
+		problems = append(problems, pruneProblems(resources)...)
+	}
+	if len(problems) > 0 {
+		return nil, problemsError("invalid ClusterPackages", problems)
+	}
+
+	if f.WarmCache {
+		if err := f.wait(ctx, func() error { return f.warmCache(ctx, jobs) }); err != nil {
 			return nil, err
 		}
 
-		// Append the new package nodes. The ClusterPackages resource is discarded as it has now been fully processed.
-		output = append(output, newNodes...)
+		f.collectCacheGarbage()
+		return input, nil
 	}
 
-	return output, nil
-}
+	// Check that every ClusterPackages spec agrees with its lock before fetching anything.
+	if f.lockMode() == LockModeVerify {
+		var problems []string
+		for _, res := range resources {
+			if res == nil {
+				continue
+			}
 
-// fetchClusterResources
-func (f *ClusterPackagesFilter) fetchClusterResources(ctx context.Context, res *ClusterPackages) ([]*yaml.RNode, error) {
-	var output []*yaml.RNode
-	for _, pkg := range res.Spec.Packages {
-		nodes, err := f.fetchPackage(ctx, &pkg)
+			lock, ok := locks[clusterPackagesLockKey(res)]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("ClusterPackages %s has no lock", res.Name))
+				continue
+			}
+
+			for _, p := range verifySpec(res, lock) {
+				problems = append(problems, fmt.Sprintf("ClusterPackages %s: %s", res.Name, p))
+			}
+		}
+
+		if len(problems) > 0 {
+			return nil, problemsError("lock does not match spec", problems)
+		}
+	}
+
+	// Determine which previously rendered resources will be pruned before fetching anything, so that the sync
+	// fails fast if it would prune resources that it did not render.
+	var pruned map[int]bool
+	if f.Prune {
+		var err error
+		pruned, err = pruneCandidates(input, resources, inventories)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		var pkgFilters []kio.Filter
-		for _, v := range res.Spec.Variables {
-			pkgFilters = append(pkgFilters, &SetPackageFilter{
-				Name:       v.Name,
-				Value:      v.Value,
-				ListValues: v.ListValues,
-				SetBy:      SetByClusterOverride,
-			})
+	// Check that every package can be fetched from the cache before fetching anything, so that all of the missing
+	// repositories and refs are reported together.
+	if f.Offline {
+		if err := f.checkCached(jobs); err != nil {
+			return nil, err
 		}
+	}
+
+	// Fetch and process all of the resources for all of the packages defined in the ClusterPackages specs.
+	results := make([][]*yaml.RNode, len(jobs))
+	lockedPackages := make([]LockedPackage, len(jobs))
+	invalidVariables := make([]variableProblems, len(jobs))
+	err := f.wait(ctx, func() error {
+		return parallelFor(ctx, f.Concurrency, len(jobs), func(ctx context.Context, i int) error {
+			nodes, locked, err := f.fetchClusterResources(ctx, jobs[i].resource, jobs[i].pkg)
+			// Invalid variables do not stop the other packages from being fetched, so that the invalid variables of
+			// every package are reported together.
+			if problems, ok := err.(variableProblems); ok {
+				invalidVariables[i] = problems
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			results[i] = nodes
+			lockedPackages[i] = locked
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range invalidVariables {
+		problems = append(problems, p...)
+	}
+	if len(problems) > 0 {
+		return nil, problemsError("invalid variables", problems)
+	}
 
-		for _, v := range pkg.Variables {
-			pkgFilters = append(pkgFilters, &SetPackageFilter{
-				Name:       v.Name,
-				Value:      v.Value,
-				ListValues: v.ListValues,
-				SetBy:      SetByPackageOverride,
-			})
+	// Assemble the output in input order, so that it does not depend on the order in which packages were fetched.
+	var output []*yaml.RNode
+	next := 0
+	for i, node := range input {
+		if resources[i] == nil {
+			// Existing locks and inventories are replaced by those emitted for their ClusterPackages resources
+			// below, and pruned resources are replaced by the newly rendered package nodes.
+			if key, ok := replaced[i]; (!ok || !emitted[key]) && !pruned[i] {
+				output = append(output, node)
+			}
+			continue
 		}
 
-		pkgFilters = append(pkgFilters, &TemplateFilter{})
+		// When pruning, the output replaces the contents of the package directory in-place, so the ClusterPackages
+		// resource is retained. Otherwise it is discarded as it has now been fully processed.
+		if f.Prune {
+			output = append(output, node)
+		}
 
-		pkgFilters = append(pkgFilters, &UpdatePathFilter{
-			Func: func(path string) (string, error) {
-				return filepath.Join(res.Spec.BaseDir, pkg.Name, path), nil
-			},
-		})
+		// Append the new package nodes.
+		start := next
+		var rendered []*yaml.RNode
+		for range resources[i].Spec.Packages {
+			rendered = append(rendered, results[next]...)
+			next++
+		}
+		output = append(output, rendered...)
 
-		for _, f := range pkgFilters {
-			nodes, err = f.Filter(nodes)
+		if f.Prune {
+			inventoryNode, err := newInventoryNode(resources[i], rendered)
 			if err != nil {
 				return nil, err
 			}
+			output = append(output, inventoryNode)
+		}
+
+		if f.lockMode() == LockModeNone {
+			continue
 		}
 
-		output = append(output, nodes...)
+		fetched := lockedPackages[start:next]
+		if f.lockMode() == LockModeVerify {
+			for _, p := range verifyFetched(fetched, locks[clusterPackagesLockKey(resources[i])]) {
+				problems = append(problems, fmt.Sprintf("ClusterPackages %s: %s", resources[i].Name, p))
+			}
+		}
+
+		lockNode, err := newLockNode(resources[i], fetched)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, lockNode)
 	}
 
+	if len(problems) > 0 {
+		return nil, problemsError("lock does not match fetched packages", problems)
+	}
+
+	f.collectCacheGarbage()
 	return output, nil
 }
 
-func (f *ClusterPackagesFilter) fetchPackage(ctx context.Context, pkg *Package) ([]*yaml.RNode, error) {
-	var repoDir string
-	var subDirectory string
+// collectCacheGarbage removes cached Git repositories according to the CacheMaxAge and CacheMaxSize. The cache is
+// left untouched in offline mode, and failures are logged rather than failing the sync.
+func (f *ClusterPackagesFilter) collectCacheGarbage() {
+	if f.Offline {
+		return
+	}
+
+	if err := f.collectGarbage(); err != nil {
+		f.Logger.Warn().Err(err).Msgf("Could not remove unused repositories from the cache")
+	}
+}
+
+// wait invokes fn and waits until it returns or the configured Timeout expires, whichever happens first.
+func (f *ClusterPackagesFilter) wait(ctx context.Context, fn func() error) error {
+	err := waitContext(ctx, fn)
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("timed out after %s fetching packages", f.Timeout)
+	}
+
+	return err
+}
+
+// lockMode returns the configured LockMode, defaulting to LockModeNone.
+func (f *ClusterPackagesFilter) lockMode() LockMode {
+	if f.LockMode == "" {
+		return LockModeNone
+	}
+
+	return f.LockMode
+}
+
+// packageJob identifies a single package of a ClusterPackages resource that is to be fetched and processed.
+type packageJob struct {
+	resource *ClusterPackages
+	pkg      *Package
+}
+
+// fetchClusterResources fetches the specified package of a ClusterPackages resource and applies the cluster-level
+// and package-level variables to it. The returned LockedPackage records what was fetched.
+func (f *ClusterPackagesFilter) fetchClusterResources(
+	ctx context.Context, res *ClusterPackages, pkg *Package) ([]*yaml.RNode, LockedPackage, error) {
+	nodes, locked, err := f.fetchPackage(ctx, res, pkg)
+	if err != nil {
+		return nil, locked, err
+	}
+
+	nodes, err = f.selectPackageNodes(pkg, nodes)
+	if err != nil {
+		return nil, locked, err
+	}
+
+	problems, err := validateVariables(res, pkg, nodes)
+	if err != nil {
+		return nil, locked, err
+	}
+
+	unknown, err := unknownVariables(pkg, nodes)
+	if err != nil {
+		return nil, locked, err
+	}
+	if len(unknown) > 0 && f.Strict {
+		for _, name := range unknown {
+			problems = append(problems, fmt.Sprintf("package %s package variable %s does not match any setter",
+				pkg.Name, name))
+		}
+	} else if len(unknown) > 0 {
+		f.Logger.Warn().Msgf("Variables of package %s do not match any setter: %s", pkg.Name, strings.Join(unknown, ", "))
+	}
+
+	if len(problems) > 0 {
+		return nil, locked, variableProblems(problems)
+	}
+
+	var pkgFilters []kio.Filter
+	for _, v := range res.Spec.Variables {
+		pkgFilters = append(pkgFilters, &SetPackageFilter{
+			Name:       v.Name,
+			Value:      v.Value,
+			ListValues: v.ListValues,
+			SetBy:      SetByClusterOverride,
+		})
+	}
+
+	for _, v := range pkg.Variables {
+		pkgFilters = append(pkgFilters, &SetPackageFilter{
+			Name:       v.Name,
+			Value:      v.Value,
+			ListValues: v.ListValues,
+			SetBy:      SetByPackageOverride,
+		})
+	}
+
+	pkgFilters = append(pkgFilters, &CascadeSettersFilter{}, requiredSettersFilter(pkg), &TemplateFilter{})
+
+	if len(pkg.Patches) > 0 {
+		pkgFilters = append(pkgFilters, kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+			return f.patchPackageNodes(res, pkg, nodes)
+		}))
+	}
+
+	if len(packagePipeline(res, pkg)) > 0 {
+		pkgFilters = append(pkgFilters, f.pipelineFilter(res, pkg))
+	}
+
+	if f.Prune {
+		pkgFilters = append(pkgFilters, ownedByFilter(res))
+	}
+
+	pkgFilters = append(pkgFilters, &UpdatePathFilter{
+		Func: func(path string) (string, error) {
+			return filepath.Join(res.Spec.BaseDir, pkg.Name, path), nil
+		},
+	})
+
+	for _, f := range pkgFilters {
+		nodes, err = f.Filter(nodes)
+		if err != nil {
+			return nil, locked, err
+		}
+	}
+
+	return nodes, locked, nil
+}
+
+// fetchPackage reads the resources of the specified package of a ClusterPackages resource from either its local
+// directory or its Git repository. The returned LockedPackage records the resolved commit and the digest of the
+// package contents.
+func (f *ClusterPackagesFilter) fetchPackage(
+	ctx context.Context, res *ClusterPackages, pkg *Package) ([]*yaml.RNode, LockedPackage, error) {
+	locked := newLockedPackage(pkg)
+	var packageDir string
 
 	if pkg.Local.Directory != "" {
-		subDirectory = "."
-		workdir, err := os.Getwd()
+		var err error
+		packageDir, err = f.localPackageDir(res, pkg)
 		if err != nil {
-			return nil, errors.WrapPrefixf(err, "error getting workdir")
+			return nil, locked, err
 		}
-		repoDir = filepath.Join(workdir, pkg.Local.Directory)
 	} else {
-		// The repository for the specified package will be cached at ${cacheDir}/${checksum} where
-		// checksum is the sha256 sum of the repository URI.
-		checksum := sha256.Sum256([]byte(pkg.Git.Repo))
-		repoDir = filepath.Join(f.CacheDir, hex.EncodeToString(checksum[:]))
-
-		// Determine whether the repository has already been cloned and cached.
-		isCached := false
-		stat, err := os.Stat(repoDir)
+		// Each package is materialised into its own directory so that packages from the same repository at
+		// different refs can be read concurrently.
+		var err error
+		packageDir, err = ioutil.TempDir("", "kpt-sync-")
 		if err != nil {
-			if !os.IsNotExist(err) {
-				return nil, errors.WrapPrefixf(err, "error checking for directory %s", repoDir)
-			}
-		} else {
-			if stat.IsDir() {
-				isCached = true
-			} else {
-				return nil, errors.Errorf("unexpected non-directory %s exists", repoDir)
-			}
+			return nil, locked, errors.WrapPrefixf(err, "could not create temporary package directory")
 		}
-
-		var repo *git.Repository
-		if !isCached {
-			f.Logger.Debug().Msgf("Cloning repository %s to %s", pkg.Git.Repo, repoDir)
-
-			var auth ssh.AuthMethod
-
-			switch f.AuthMethod {
-			case AuthMethodKeyFile:
-				auth, err = ssh.NewPublicKeys("git", f.GitPrivateKey, "")
-				if err != nil {
-					return nil, errors.WrapPrefixf(err, "error retrieving Git private key information")
-				}
-
-			case AuthMethodSSHAgent:
-				if os.Getenv(AuthSockEnvVar) == "" {
-					return nil, errors.Errorf("Env variable %s must be defined to use ssh agent auth", AuthSockEnvVar)
-				}
-				auth, err = ssh.NewSSHAgentAuth("git")
-				if err != nil {
-					return nil, errors.WrapPrefixf(err, "error using ssh agent auth")
-				}
-
-			default:
-				repoUrl, err := url.Parse(pkg.Git.Repo)
-				if err != nil {
-					return nil, errors.WrapPrefixf(err, "failed to parse repo URL")
-				}
-
-				if repoUrl.Scheme != HTTPSScheme && repoUrl.Scheme != "" {
-					return nil, errors.Errorf("got invalid scheme %s for anonymous authentication, use https scheme instead", repoUrl.Scheme)
-				}
-				auth = nil
+		defer func() {
+			if err := os.RemoveAll(packageDir); err != nil {
+				f.Logger.Warn().Err(err).Msgf("Could not delete temporary package directory %s", packageDir)
 			}
+		}()
 
-			cloneOptions := &git.CloneOptions{
-				URL: pkg.Git.Repo,
-			}
+		hash, repoDir, unlock, err := f.resolvePackage(ctx, pkg)
+		if err != nil {
+			return nil, locked, err
+		}
+		locked.Git.Commit = hash.String()
 
-			if auth != nil {
-				cloneOptions.Auth = auth
-			}
+		// The lock taken while resolving the package is held until it has been materialised, so that the cached
+		// repository cannot be evicted by another process in between.
+		err = f.materialisePackage(pkg, hash, repoDir, packageDir)
+		unlock()
+		if err != nil {
+			return nil, locked, err
+		}
+	}
 
-			repo, err = git.PlainCloneContext(ctx, repoDir, false, cloneOptions)
-			if err != nil {
-				return nil, errors.WrapPrefixf(err, "error cloning Git repository %s", pkg.Git.Repo)
-			}
-		} else {
-			f.Logger.Debug().Msgf("Using %s in %s", pkg.Git.Repo, repoDir)
+	reader := kio.LocalPackageReader{
+		PackagePath:    packageDir,
+		MatchFilesGlob: resourceFileGlobs,
+	}
 
-			repo, err = git.PlainOpen(repoDir)
-			if err != nil {
-				return nil, errors.WrapPrefixf(err, "error opening Git repository %s", pkg.Git.Repo)
-			}
-		}
+	nodes, err := reader.Read()
+	if err != nil {
+		return nil, locked, errors.WrapPrefixf(err, "error reading resources from %s", packageDir)
+	}
+
+	files, err := readPackageFiles(packageDir, packageFileGlobs(res, pkg))
+	if err != nil {
+		return nil, locked, err
+	}
+	nodes = append(nodes, files...)
 
-		w, err := repo.Worktree()
+	if f.lockMode() != LockModeNone {
+		locked.Digest, err = digestDirectory(packageDir)
 		if err != nil {
-			return nil, errors.WrapPrefixf(err, "error obtaining worktree for repository %s", pkg.Git.Repo)
+			return nil, locked, err
 		}
+	}
+
+	return nodes, locked, nil
+}
 
-		if err := w.Checkout(&git.CheckoutOptions{
-			Hash:  plumbing.NewHash(pkg.Git.Ref),
-			Force: true,
-		}); err != nil {
-			return nil, errors.WrapPrefixf(err, "error checking out ref %s for repository %s", pkg.Git.Ref, pkg.Git.Repo)
+// resolvePackage clones or refreshes the cached repository of the specified Git package as required and resolves
+// the package's ref to a commit. The directory of the cached repository that contains the commit is also returned,
+// along with a function that releases the lock on the repository, which must be held while the package is read from
+// it. The lock is shared if the ref could be resolved from the cache, and exclusive if the repository was cloned or
+// fetched.
+func (f *ClusterPackagesFilter) resolvePackage(
+	ctx context.Context, pkg *Package) (plumbing.Hash, string, func(), error) {
+	if f.Shallow && isShallowRef(pkg.Git.Ref) {
+		hash, repoDir, unlock, err := f.resolveShallow(ctx, pkg.Git.Repo, pkg.Git.Ref)
+		if err == nil {
+			f.Logger.Debug().Msgf("Resolved ref %s for repository %s to %s", pkg.Git.Ref, pkg.Git.Repo, hash)
+			return hash, repoDir, unlock, nil
+		}
+		if ctx.Err() != nil {
+			return plumbing.ZeroHash, "", nil, ctx.Err()
 		}
 
-		subDirectory = pkg.Git.Directory
+		f.Logger.Debug().Msgf("Could not fetch ref %s of repository %s shallowly, fetching all refs: %v",
+			pkg.Git.Ref, pkg.Git.Repo, err)
 	}
 
-	reader := kio.LocalPackageReader{
-		PackagePath:    filepath.Join(repoDir, subDirectory),
-		MatchFilesGlob: append(kio.DefaultMatch, kptfile.KptFileName),
+	repoDir := f.repositoryDir(pkg.Git.Repo)
+	if hash, unlock, ok := f.resolveShared(pkg.Git.Repo, repoDir, pkg.Git.Ref); ok {
+		f.Logger.Debug().Msgf("Resolved ref %s for repository %s to %s", pkg.Git.Ref, pkg.Git.Repo, hash)
+		return hash, repoDir, unlock, nil
 	}
 
-	nodes, err := reader.Read()
+	unlock, err := f.lockRepository(repoDir, true)
+	if err != nil {
+		return plumbing.ZeroHash, "", nil, err
+	}
+
+	repo, cloned, err := f.openRepository(ctx, pkg.Git.Repo)
+	if err != nil {
+		unlock()
+		return plumbing.ZeroHash, "", nil, err
+	}
+
+	hash, err := f.resolveRepositoryRef(ctx, repo, pkg.Git.Repo, pkg.Git.Ref, cloned)
+	if err != nil {
+		unlock()
+		return plumbing.ZeroHash, "", nil, errors.WrapPrefixf(err, "error resolving ref %s for repository %s",
+			pkg.Git.Ref, pkg.Git.Repo)
+	}
+
+	f.Logger.Debug().Msgf("Resolved ref %s for repository %s to %s", pkg.Git.Ref, pkg.Git.Repo, hash)
+
+	return hash, repoDir, unlock, nil
+}
+
+// resolveShared resolves the specified ref in the specified repository, cached in the specified directory, under a
+// shared lock, so that packages from the same repository are resolved and materialised concurrently when it does not
+// need to be cloned or fetched. If the ref is resolved, the lock is retained and a function that releases it is
+// returned. Otherwise, including when the repository is not cached or the CachePolicy requires it to be refreshed, the
+// lock is released and the returned boolean is false, so that the caller can clone or fetch the repository under an
+// exclusive lock.
+func (f *ClusterPackagesFilter) resolveShared(repoURL, repoDir, ref string) (plumbing.Hash, func(), bool) {
+	if f.cachePolicy(repoURL) == CachePolicyAlways {
+		return plumbing.ZeroHash, nil, false
+	}
+	if _, err := os.Stat(repoDir); err != nil {
+		return plumbing.ZeroHash, nil, false
+	}
+
+	unlock, err := f.lockRepository(repoDir, false)
 	if err != nil {
-		return nil, errors.WrapPrefixf(err, "error reading resources from %s", repoDir)
+		return plumbing.ZeroHash, nil, false
+	}
+
+	hash, err := resolveRepositoryDirRef(repoDir, ref)
+	if err != nil {
+		unlock()
+		return plumbing.ZeroHash, nil, false
+	}
+
+	return hash, unlock, true
+}
+
+// materialisePackage writes the files of the specified Git package at the specified commit of the cached repository
+// in the specified directory to the destination directory. The lock on the repository that was returned by
+// resolvePackage must be held.
+func (f *ClusterPackagesFilter) materialisePackage(pkg *Package, hash plumbing.Hash, repoDir, dest string) error {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return errors.WrapPrefixf(err, "error opening Git repository %s", pkg.Git.Repo)
+	}
+
+	if err := materialiseTree(repo, hash, pkg.Git.Directory, dest); err != nil {
+		return errors.WrapPrefixf(err, "error checking out ref %s for repository %s", pkg.Git.Ref, pkg.Git.Repo)
 	}
 
-	return nodes, nil
+	return nil
 }
@@ -0,0 +1,80 @@
+package filters
+
+import (
+	"net/url"
+	"strings"
+)
+
+// GitCredentials defines how to authenticate to a Git repository.
+type GitCredentials struct {
+	// AuthMethod specifies the method to use for authenticating to Git repositories
+	AuthMethod AuthMethod
+	// GitPrivateKey specifies the the private key to use for Git.
+	GitPrivateKey []byte
+	// GitKeyPassphrase specifies the passphrase of the private key, if it is encrypted.
+	GitKeyPassphrase string
+	// GitUsername specifies the username to use for Git over HTTPS.
+	GitUsername string
+	// GitPassword specifies the token or password to use for Git over HTTPS.
+	GitPassword string
+}
+
+// CredentialRule maps the repositories matching a URL prefix or a host to the credentials used to access them.
+type CredentialRule struct {
+	// Prefix specifies the prefix of the repository URLs that the rule applies to.
+	Prefix string
+	// Host specifies the host of the repositories that the rule applies to. Prefix rules take precedence over
+	// host rules.
+	Host string
+	// Credentials specifies the credentials used for matching repositories.
+	Credentials GitCredentials
+}
+
+// credentials returns the credentials to use for the specified repository URI. The rule with the longest
+// matching prefix is used, followed by the first rule with a matching host, falling back to the default
+// credentials of the filter.
+func (f *ClusterPackagesFilter) credentials(repoURL string) GitCredentials {
+	var match *CredentialRule
+	for i := range f.CredentialRules {
+		rule := &f.CredentialRules[i]
+		if rule.Prefix != "" && strings.HasPrefix(repoURL, rule.Prefix) &&
+			(match == nil || len(rule.Prefix) > len(match.Prefix)) {
+			match = rule
+		}
+	}
+
+	if match == nil {
+		host := repositoryHost(repoURL)
+		for i := range f.CredentialRules {
+			if rule := &f.CredentialRules[i]; rule.Host != "" && strings.EqualFold(rule.Host, host) {
+				match = rule
+				break
+			}
+		}
+	}
+
+	if match == nil {
+		return f.GitCredentials
+	}
+
+	f.Logger.Debug().Msgf("Using %s auth for %s", match.Credentials.AuthMethod, repoURL)
+	return match.Credentials
+}
+
+// repositoryHost returns the host name of the specified repository URI. Both URLs and scp-like addresses of the
+// form user@host:path are supported.
+func repositoryHost(repoURL string) string {
+	if u, err := url.Parse(repoURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+
+	host := repoURL
+	if i := strings.Index(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i >= 0 {
+		return host[:i]
+	}
+
+	return ""
+}
@@ -0,0 +1,40 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestCredentials(t *testing.T) {
+	f := &ClusterPackagesFilter{
+		Logger:         zerolog.Nop(),
+		GitCredentials: GitCredentials{AuthMethod: AuthMethodNone},
+		CredentialRules: []CredentialRule{
+			{Host: "github.example.com", Credentials: GitCredentials{AuthMethod: AuthMethodKeyFile}},
+			{Prefix: "https://bitbucket.org/", Credentials: GitCredentials{AuthMethod: AuthMethodBasicAuth}},
+			{Prefix: "https://bitbucket.org/seek/", Credentials: GitCredentials{AuthMethod: AuthMethodHTTPSToken}},
+			{Host: "bitbucket.org", Credentials: GitCredentials{AuthMethod: AuthMethodSSHAgent}},
+		},
+	}
+
+	var tests = []struct {
+		repo     string
+		expected AuthMethod
+	}{
+		{repo: "https://github.com/seek-oss/kpt-functions", expected: AuthMethodNone},
+		{repo: "ssh://git@github.example.com/seek/packages", expected: AuthMethodKeyFile},
+		{repo: "git@github.example.com:seek/packages.git", expected: AuthMethodKeyFile},
+		{repo: "https://bitbucket.org/other/packages", expected: AuthMethodBasicAuth},
+		{repo: "https://bitbucket.org/seek/packages", expected: AuthMethodHTTPSToken},
+		{repo: "ssh://git@bitbucket.org/seek/packages", expected: AuthMethodSSHAgent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repo, func(t *testing.T) {
+			if actual := f.credentials(test.repo).AuthMethod; actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package filters
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/GoogleContainerTools/kpt/pkg/kptfile"
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// PackageFileKind defines the kind used by the PackageFile resource.
+	PackageFileKind = "PackageFile"
+
+	// base64Encoding defines the encoding of the content of PackageFile resources that wrap files that are not
+	// valid UTF-8.
+	base64Encoding = "base64"
+)
+
+// resourceFileGlobs defines the globs of the files of a package that are read as resources.
+var resourceFileGlobs = append(append([]string{}, kio.DefaultMatch...), kptfile.KptFileName)
+
+// PackageFile wraps a file of a package that is not a Kubernetes resource, such as a README, a JSON dashboard or a
+// script, so that it can be carried through a ResourceList. Its config.kubernetes.io/path annotation specifies the
+// path that the file is written to by the WriteFilesFilter.
+type PackageFile struct {
+	// Standard Kubernetes metadata. The name is the path of the file within its package.
+	yaml.ResourceMeta `json:",inline" yaml:",inline"`
+	// Spec provides the resource specification.
+	Spec PackageFileSpec `yaml:"spec,omitempty"`
+}
+
+// PackageFileSpec defines the main body of the PackageFile resource.
+type PackageFileSpec struct {
+	// Content specifies the verbatim content of the file, encoded according to the Encoding.
+	Content string `yaml:"content,omitempty"`
+	// Encoding specifies the encoding of the Content. It is empty for UTF-8 text and base64 for other files.
+	Encoding string `yaml:"encoding,omitempty"`
+	// Executable specifies whether the file is executable.
+	Executable bool `yaml:"executable,omitempty"`
+}
+
+// isPackageFile returns whether the specified resource metadata pertains to a PackageFile resource.
+func isPackageFile(meta yaml.ResourceMeta) bool {
+	return meta.APIVersion == ClusterPackagesAPIVersion && meta.Kind == PackageFileKind
+}
+
+// packageFileGlobs returns the globs of the additional files that are included for the specified package of the
+// specified ClusterPackages resource.
+func packageFileGlobs(res *ClusterPackages, pkg *Package) []string {
+	return append(append([]string{}, res.Spec.Files...), pkg.Files...)
+}
+
+// readPackageFiles returns PackageFile resources that wrap the files under the specified package directory that
+// match any of the specified globs and are not read as resources. Globs without a slash are matched against file
+// names, and globs with a slash against paths relative to the package directory.
+func readPackageFiles(packageDir string, globs []string) ([]*yaml.RNode, error) {
+	if len(globs) == 0 {
+		return nil, nil
+	}
+
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, errors.WrapPrefixf(err, "invalid file glob %q", glob)
+		}
+	}
+
+	var nodes []*yaml.RNode
+	err := filepath.Walk(packageDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || matchesAny(resourceFileGlobs, info.Name(), "") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(packageDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !matchesAny(globs, info.Name(), rel) {
+			return nil
+		}
+
+		node, err := newPackageFileNode(p, rel, info)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WrapPrefixf(err, "error reading files from %s", packageDir)
+	}
+
+	return nodes, nil
+}
+
+// matchesAny returns whether a file with the specified name and relative path matches any of the specified globs.
+func matchesAny(globs []string, name, rel string) bool {
+	for _, glob := range globs {
+		target := name
+		if strings.Contains(glob, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(glob, target); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newPackageFileNode returns the PackageFile resource node that wraps the specified file, which has the specified
+// path relative to its package.
+func newPackageFileNode(p, rel string, info os.FileInfo) (*yaml.RNode, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	file := PackageFile{
+		ResourceMeta: yaml.ResourceMeta{
+			TypeMeta: yaml.TypeMeta{APIVersion: ClusterPackagesAPIVersion, Kind: PackageFileKind},
+			ObjectMeta: yaml.ObjectMeta{
+				NameMeta:    yaml.NameMeta{Name: rel},
+				Annotations: map[string]string{kioutil.PathAnnotation: rel},
+			},
+		},
+		Spec: PackageFileSpec{
+			Content:    string(b),
+			Executable: info.Mode()&0111 != 0,
+		},
+	}
+	if !utf8.Valid(b) {
+		file.Spec.Content = base64.StdEncoding.EncodeToString(b)
+		file.Spec.Encoding = base64Encoding
+	}
+
+	out, err := yaml.Marshal(file)
+	if err != nil {
+		return nil, errors.WrapPrefixf(err, "could not marshal %s", rel)
+	}
+
+	return yaml.Parse(string(out))
+}
+
+// WriteFilesFilter defines a kio.Filter that writes the files wrapped by PackageFile resources verbatim to a
+// directory and removes the PackageFile resources from the output. All other resources are passed through.
+type WriteFilesFilter struct {
+	// Dir specifies the directory that the config.kubernetes.io/path annotations of the PackageFile resources are
+	// relative to.
+	Dir string
+	// Logger specifies the logger to be used by the filter.
+	Logger zerolog.Logger
+}
+
+// Filter implements kio.Filter.Filter.
+func (f *WriteFilesFilter) Filter(input []*yaml.RNode) ([]*yaml.RNode, error) {
+	var output []*yaml.RNode
+	for _, node := range input {
+		meta, err := node.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		if !isPackageFile(meta) {
+			output = append(output, node)
+			continue
+		}
+
+		if err := f.writeFile(node, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	return output, nil
+}
+
+// writeFile writes the file wrapped by the specified PackageFile resource.
+func (f *WriteFilesFilter) writeFile(node *yaml.RNode, meta yaml.ResourceMeta) error {
+	p := meta.Annotations[kioutil.PathAnnotation]
+	if p == "" {
+		return errors.Errorf("PackageFile %s has no %s annotation", meta.Name, kioutil.PathAnnotation)
+	}
+	if problem := relativePathProblem(p, "the output directory"); problem != "" {
+		return errors.Errorf("PackageFile %s path %q %s", meta.Name, p, problem)
+	}
+
+	file := &PackageFile{}
+	if err := yaml.Unmarshal([]byte(node.MustString()), file); err != nil {
+		return errors.WrapPrefixf(err, "could not unmarshal PackageFile %s", meta.Name)
+	}
+
+	content := []byte(file.Spec.Content)
+	switch file.Spec.Encoding {
+	case "":
+	case base64Encoding:
+		var err error
+		content, err = base64.StdEncoding.DecodeString(file.Spec.Content)
+		if err != nil {
+			return errors.WrapPrefixf(err, "could not decode PackageFile %s", meta.Name)
+		}
+	default:
+		return errors.Errorf("PackageFile %s encoding %s is invalid", meta.Name, file.Spec.Encoding)
+	}
+
+	var mode os.FileMode = 0644
+	if file.Spec.Executable {
+		mode = 0755
+	}
+
+	target := filepath.Join(f.Dir, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return errors.WrapPrefixf(err, "error creating directory for %s", target)
+	}
+	if err := ioutil.WriteFile(target, content, mode); err != nil {
+		return errors.WrapPrefixf(err, "error writing %s", target)
+	}
+	// WriteFile does not change the mode of existing files.
+	if err := os.Chmod(target, mode); err != nil {
+		return errors.WrapPrefixf(err, "error setting mode of %s", target)
+	}
+
+	f.Logger.Debug().Msgf("Wrote %s", target)
+	return nil
+}
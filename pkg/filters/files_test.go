@@ -0,0 +1,116 @@
+package filters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestPackageFilesRoundTrip(t *testing.T) {
+	files := map[string]struct {
+		content string
+		mode    os.FileMode
+	}{
+		"README.md":                 {content: "# Sample\n\nTrailing spaces   \n\ttabs\r\n", mode: 0644},
+		"Kptfile":                   {content: "apiVersion: kpt.dev/v1alpha1\nkind: Kptfile\n", mode: 0644},
+		"deployment.yaml":           {content: "apiVersion: apps/v1\nkind: Deployment\n", mode: 0644},
+		"dashboards/app.json":       {content: `{"title": "{{ .Values.app }}"}`, mode: 0644},
+		"scripts/run.sh":            {content: "#!/bin/sh\necho hello\n", mode: 0755},
+		"images/logo.bin":           {content: "\xff\xfe\x00binary", mode: 0644},
+		"policies/ignored/deny.txt": {content: "ignored", mode: 0644},
+	}
+
+	packageDir := t.TempDir()
+	for name, file := range files {
+		p := filepath.Join(packageDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(file.content), file.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodes, err := readPackageFiles(packageDir, []string{"*.md", "*.json", "scripts/*", "*.bin", "*.yaml", "Kptfile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Round trip the nodes through YAML as a ResourceList would.
+	var roundTripped []*yaml.RNode
+	var names []string
+	for _, node := range nodes {
+		parsed, err := yaml.Parse(node.MustString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := parsed.GetMeta()
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTripped = append(roundTripped, parsed)
+		names = append(names, meta.Name)
+	}
+	sort.Strings(names)
+
+	expected := []string{"README.md", "dashboards/app.json", "images/logo.bin", "scripts/run.sh"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+
+	outDir := t.TempDir()
+	f := &WriteFilesFilter{Dir: outDir, Logger: zerolog.Nop()}
+	output, err := f.Filter(roundTripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != 0 {
+		t.Errorf("expected PackageFile resources to be removed, got %d resources", len(output))
+	}
+
+	for _, name := range expected {
+		p := filepath.Join(outDir, filepath.FromSlash(name))
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != files[name].content {
+			t.Errorf("expected %s to contain %q, got %q", name, files[name].content, string(b))
+		}
+
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != files[name].mode {
+			t.Errorf("expected %s to have mode %s, got %s", name, files[name].mode, info.Mode().Perm())
+		}
+	}
+}
+
+func TestWriteFilesFilterRejectsTraversal(t *testing.T) {
+	node, err := yaml.Parse(`
+apiVersion: kpt.seek.com/v1alpha1
+kind: PackageFile
+metadata:
+  name: escape
+  annotations:
+    config.kubernetes.io/path: ../escape.sh
+spec:
+  content: echo escaped
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &WriteFilesFilter{Dir: t.TempDir(), Logger: zerolog.Nop()}
+	if _, err := f.Filter([]*yaml.RNode{node}); err == nil || !strings.Contains(err.Error(), "must not be outside") {
+		t.Errorf("expected traversal to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package filters
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
+
+// CachePolicy defines when a cached Git repository is refreshed from its remote.
+type CachePolicy string
+
+const (
+	// CachePolicyIfMissing refreshes a cached repository only when the requested ref cannot be found in it.
+	CachePolicyIfMissing CachePolicy = "ifMissing"
+	// CachePolicyAlways refreshes a cached repository every time it is used.
+	CachePolicyAlways CachePolicy = "always"
+	// CachePolicyNever never refreshes a cached repository.
+	CachePolicyNever CachePolicy = "never"
+)
+
+// fetchRefSpecs defines the refspecs used when refreshing a cached repository. These match
+// the refspecs configured for the origin remote by a regular clone.
+var fetchRefSpecs = []config.RefSpec{
+	config.RefSpec("+refs/heads/*:refs/remotes/" + git.DefaultRemoteName + "/*"),
+}
+
+// repositoryDir returns the directory that the specified repository is cached in. The repository will be cached
+// at ${cacheDir}/${checksum} where checksum is the sha256 sum of the repository URI.
+func (f *ClusterPackagesFilter) repositoryDir(repoURL string) string {
+	checksum := sha256.Sum256([]byte(repoURL))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(checksum[:]))
+}
+
+// openRepository returns the cached repository for the specified URI, cloning it into the cache if it is not
+// already cached. The returned boolean is true if the repository was cloned by this call.
+func (f *ClusterPackagesFilter) openRepository(ctx context.Context, repoURL string) (*git.Repository, bool, error) {
+	repoDir := f.repositoryDir(repoURL)
+
+	// Determine whether the repository has already been cloned and cached.
+	stat, err := os.Stat(repoDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, false, errors.WrapPrefixf(err, "error checking for directory %s", repoDir)
+		}
+	} else {
+		if !stat.IsDir() {
+			return nil, false, errors.Errorf("unexpected non-directory %s exists", repoDir)
+		}
+
+		f.Logger.Debug().Msgf("Using %s in %s", repoURL, repoDir)
+
+		repo, err := git.PlainOpen(repoDir)
+		if err != nil {
+			return nil, false, errors.WrapPrefixf(err, "error opening Git repository %s", repoURL)
+		}
+
+		return repo, false, nil
+	}
+
+	var repo *git.Repository
+	err = f.withRemotes(ctx, repoURL, func(ctx context.Context, remoteURL string) error {
+		f.Logger.Debug().Msgf("Cloning repository %s to %s", describeRemote(repoURL, remoteURL), repoDir)
+
+		auth, err := f.auth(remoteURL)
+		if err != nil {
+			return err
+		}
+
+		// Packages are materialised directly from the repository's object database, so the cached
+		// repository's worktree is never checked out.
+		repo, err = git.PlainCloneContext(ctx, repoDir, false, &git.CloneOptions{
+			URL:        remoteURL,
+			Auth:       auth,
+			NoCheckout: true,
+		})
+		if err != nil {
+			return errors.WrapPrefixf(err, "error cloning Git repository %s", describeRemote(repoURL, remoteURL))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return repo, true, nil
+}
+
+// fetchRepository fetches new branches, tags and objects into a cached repository from its remote. The remote URL is
+// determined on every fetch rather than read from the repository config, so that changes to the URLRewrites and
+// Mirrors apply to repositories that are already cached.
+func (f *ClusterPackagesFilter) fetchRepository(ctx context.Context, repo *git.Repository, repoURL string) error {
+	return f.withRemotes(ctx, repoURL, func(ctx context.Context, remoteURL string) error {
+		f.Logger.Debug().Msgf("Fetching repository %s", describeRemote(repoURL, remoteURL))
+
+		auth, err := f.auth(remoteURL)
+		if err != nil {
+			return err
+		}
+
+		remote, err := repo.CreateRemoteAnonymous(&config.RemoteConfig{
+			Name: anonymousRemoteName,
+			URLs: []string{remoteURL},
+		})
+		if err != nil {
+			return errors.WrapPrefixf(err, "error creating remote for Git repository %s", describeRemote(repoURL, remoteURL))
+		}
+
+		err = remote.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: anonymousRemoteName,
+			RefSpecs:   fetchRefSpecs,
+			Auth:       auth,
+			Tags:       git.AllTags,
+			Force:      true,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return errors.WrapPrefixf(err, "error fetching Git repository %s", describeRemote(repoURL, remoteURL))
+		}
+
+		return nil
+	})
+}
+
+// resolveRepositoryRef resolves the specified ref in a cached repository, refreshing the repository from its
+// remote according to the configured CachePolicy. The cloned argument indicates that the repository has just
+// been cloned, in which case it is never refreshed.
+func (f *ClusterPackagesFilter) resolveRepositoryRef(
+	ctx context.Context, repo *git.Repository, repoURL, ref string, cloned bool) (plumbing.Hash, error) {
+	policy := f.cachePolicy(repoURL)
+
+	if !cloned && policy == CachePolicyAlways {
+		if err := f.fetchRepository(ctx, repo, repoURL); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	hash, err := resolveRef(repo, ref)
+	if err == nil || cloned || policy != CachePolicyIfMissing || !isRefNotFound(err) {
+		return hash, err
+	}
+
+	f.Logger.Debug().Msgf("Ref %s not found in cached repository %s", ref, repoURL)
+
+	if err := f.fetchRepository(ctx, repo, repoURL); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return resolveRef(repo, ref)
+}
+
+// cachePolicy returns the CachePolicy of the specified repository, which is the configured CachePolicy, defaulting to
+// CachePolicyIfMissing. Cached repositories are never refreshed in offline mode, unless they are local.
+func (f *ClusterPackagesFilter) cachePolicy(repoURL string) CachePolicy {
+	if f.isOfflineRepository(repoURL) {
+		return CachePolicyNever
+	}
+	if f.CachePolicy == "" {
+		return CachePolicyIfMissing
+	}
+
+	return f.CachePolicy
+}
+
+// repositoryLock returns the in-process lock that guards the cached repository in the specified directory. Use
+// lockRepository, which also guards the repository against other processes, rather than using this lock directly.
+func (f *ClusterPackagesFilter) repositoryLock(repoDir string) *sync.RWMutex {
+	lock, _ := f.repoLocks.LoadOrStore(repoDir, &sync.RWMutex{})
+	return lock.(*sync.RWMutex)
+}
+
+// materialiseTree writes the files under the specified directory of the tree of the specified commit to the
+// destination directory. This allows each package to be read in isolation, without checking out the commit in
+// the shared worktree of the cached repository.
+func materialiseTree(repo *git.Repository, hash plumbing.Hash, directory, dest string) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return errors.WrapPrefixf(err, "error reading commit %s", hash)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return errors.WrapPrefixf(err, "error reading tree for commit %s", hash)
+	}
+
+	if dir := strings.Trim(path.Clean("/"+filepath.ToSlash(directory)), "/"); dir != "" {
+		tree, err = tree.Tree(dir)
+		if err != nil {
+			return errors.WrapPrefixf(err, "error reading directory %s at commit %s", directory, hash)
+		}
+	}
+
+	return tree.Files().ForEach(func(file *object.File) error {
+		target := filepath.Join(dest, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return errors.WrapPrefixf(err, "error creating directory for %s", file.Name)
+		}
+
+		contents, err := file.Contents()
+		if err != nil {
+			return errors.WrapPrefixf(err, "error reading %s at commit %s", file.Name, hash)
+		}
+
+		switch file.Mode {
+		case filemode.Symlink:
+			err = os.Symlink(contents, target)
+		case filemode.Executable:
+			err = ioutil.WriteFile(target, []byte(contents), 0755)
+		default:
+			err = ioutil.WriteFile(target, []byte(contents), 0644)
+		}
+		if err != nil {
+			return errors.WrapPrefixf(err, "error writing %s", target)
+		}
+
+		return nil
+	})
+}
+
+// auth returns the transport.AuthMethod to use for the specified repository URI based on the credentials
+// configured for it. Repositories on the local filesystem never require authentication.
+func (f *ClusterPackagesFilter) auth(repoURL string) (transport.AuthMethod, error) {
+	if isLocalRepository(repoURL) {
+		return nil, nil
+	}
+
+	creds := f.credentials(repoURL)
+
+	switch creds.AuthMethod {
+	case AuthMethodKeyFile:
+		signer, err := parsePrivateKey(creds.GitPrivateKey, creds.GitKeyPassphrase)
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "error retrieving Git private key information")
+		}
+		callback, err := f.hostKeyCallback()
+		if err != nil {
+			return nil, err
+		}
+		auth := &ssh.PublicKeys{User: "git", Signer: signer}
+		auth.HostKeyCallback = callback
+		return f.withDialTimeout(auth), nil
+
+	case AuthMethodSSHAgent:
+		if os.Getenv(AuthSockEnvVar) == "" {
+			return nil, errors.Errorf("Env variable %s must be defined to use ssh agent auth", AuthSockEnvVar)
+		}
+		auth, err := ssh.NewSSHAgentAuth("git")
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "error using ssh agent auth")
+		}
+		auth.HostKeyCallback, err = f.hostKeyCallback()
+		if err != nil {
+			return nil, err
+		}
+		return f.withDialTimeout(auth), nil
+
+	case AuthMethodHTTPSToken, AuthMethodBasicAuth:
+		repoUrl, err := url.Parse(repoURL)
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "failed to parse repo URL")
+		}
+
+		if repoUrl.Scheme != HTTPSScheme {
+			return nil, errors.Errorf("got invalid scheme %s for %s authentication, use https scheme instead", repoUrl.Scheme, creds.AuthMethod)
+		}
+
+		return &http.BasicAuth{Username: creds.GitUsername, Password: creds.GitPassword}, nil
+
+	default:
+		repoUrl, err := url.Parse(repoURL)
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "failed to parse repo URL")
+		}
+
+		if repoUrl.Scheme != HTTPSScheme && repoUrl.Scheme != "" {
+			return nil, errors.Errorf("got invalid scheme %s for anonymous authentication, use https scheme instead", repoUrl.Scheme)
+		}
+		return nil, nil
+	}
+}
+
+// parsePrivateKey parses the specified PEM encoded private key, decrypting it with the specified passphrase if it is
+// not empty. Both legacy PEM encryption and the OpenSSH private key format are supported.
+func parsePrivateKey(key []byte, passphrase string) (cryptossh.Signer, error) {
+	if passphrase != "" {
+		return cryptossh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+
+	signer, err := cryptossh.ParsePrivateKey(key)
+	if _, ok := err.(*cryptossh.PassphraseMissingError); ok {
+		return nil, errors.Errorf("Git private key is encrypted but no passphrase was configured")
+	}
+
+	return signer, err
+}
@@ -0,0 +1,298 @@
+package filters
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestResolveRepositoryRef(t *testing.T) {
+	var tests = []struct {
+		name     string
+		policy   CachePolicy
+		ref      func(old, new plumbing.Hash) string
+		expected func(old, new plumbing.Hash) plumbing.Hash
+		err      string
+	}{
+		{
+			name:     "if-missing-fetches-unknown-commit",
+			policy:   CachePolicyIfMissing,
+			ref:      func(old, new plumbing.Hash) string { return new.String() },
+			expected: func(old, new plumbing.Hash) plumbing.Hash { return new },
+		},
+		{
+			name:     "if-missing-uses-cached-branch",
+			policy:   CachePolicyIfMissing,
+			ref:      func(old, new plumbing.Hash) string { return "master" },
+			expected: func(old, new plumbing.Hash) plumbing.Hash { return old },
+		},
+		{
+			name:     "always-fetches-branch",
+			policy:   CachePolicyAlways,
+			ref:      func(old, new plumbing.Hash) string { return "master" },
+			expected: func(old, new plumbing.Hash) plumbing.Hash { return new },
+		},
+		{
+			name:   "never-fetches",
+			policy: CachePolicyNever,
+			ref:    func(old, new plumbing.Hash) string { return new.String() },
+			err:    "not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstreamDir, upstream, hashes := newTestRepository(t, 1)
+
+			f := &ClusterPackagesFilter{
+				CacheDir:       t.TempDir(),
+				Logger:         zerolog.Nop(),
+				GitCredentials: GitCredentials{AuthMethod: AuthMethodNone},
+				CachePolicy:    test.policy,
+			}
+
+			ctx := context.Background()
+			if _, _, err := f.openRepository(ctx, upstreamDir); err != nil {
+				t.Fatal(err)
+			}
+
+			newHashes := addTestCommits(t, upstream, 1)
+
+			repo, cloned, err := f.openRepository(ctx, upstreamDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cloned {
+				t.Fatal("expected repository to be cached")
+			}
+
+			h, err := f.resolveRepositoryRef(ctx, repo, upstreamDir, test.ref(hashes[0], newHashes[0]), cloned)
+			if test.err != "" {
+				if err == nil || !isRefNotFound(err) {
+					t.Fatalf("expected ref not found error, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := test.expected(hashes[0], newHashes[0]); h != expected {
+				t.Fatalf("expected %s, got %s", expected, h)
+			}
+		})
+	}
+}
+
+func TestClusterPackagesFilterConcurrentRefs(t *testing.T) {
+	upstreamDir, upstream, _ := newTestRepository(t, 0)
+
+	var hashes []plumbing.Hash
+	for _, value := range []string{"first", "second"} {
+		hashes = append(hashes, commitTestFiles(t, upstream, map[string]string{
+			"pkg/Kptfile": "apiVersion: kpt.dev/v1alpha1\nkind: Kptfile\nmetadata:\n  name: pkg\n",
+			"pkg/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\ndata:\n  value: " + value + "\n",
+		}))
+	}
+
+	input, err := yaml.Parse(`
+apiVersion: kpt.seek.com/v1alpha1
+kind: ClusterPackages
+metadata:
+  name: cluster
+spec:
+  baseDir: out
+  packages:
+  - name: a
+    git: {repo: ` + upstreamDir + `, directory: pkg, ref: ` + hashes[0].String() + `}
+  - name: b
+    git: {repo: ` + upstreamDir + `, directory: pkg, ref: ` + hashes[1].String() + `}
+  - name: c
+    git: {repo: ` + upstreamDir + `, directory: /pkg/, ref: ` + hashes[0].String()[:8] + `}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &ClusterPackagesFilter{
+		CacheDir:       t.TempDir(),
+		Logger:         zerolog.Nop(),
+		GitCredentials: GitCredentials{AuthMethod: AuthMethodNone},
+		Concurrency:    3,
+	}
+
+	output, err := f.Filter([]*yaml.RNode{input})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, node := range output {
+		meta, err := node.GetMeta()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, err := node.Pipe(yaml.Lookup("data", "value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual = append(actual, meta.Annotations[kioutil.PathAnnotation]+"="+yaml.GetValue(value))
+	}
+
+	expected := []string{
+		"out/a/Kptfile=", "out/a/cm.yaml=first",
+		"out/b/Kptfile=", "out/b/cm.yaml=second",
+		"out/c/Kptfile=", "out/c/cm.yaml=first",
+	}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestClusterPackagesFilterLockVerifiesCommit(t *testing.T) {
+	upstreamDir, upstream, _ := newTestRepository(t, 0)
+	files := map[string]string{
+		"pkg/Kptfile": "apiVersion: kpt.dev/v1alpha1\nkind: Kptfile\nmetadata:\n  name: pkg\n",
+	}
+	commitTestFiles(t, upstream, files)
+
+	clusterPackages := `
+apiVersion: kpt.seek.com/v1alpha1
+kind: ClusterPackages
+metadata:
+  name: cluster
+spec:
+  packages:
+  - name: a
+    git: {repo: ` + upstreamDir + `, directory: pkg, ref: master}
+`
+
+	f := &ClusterPackagesFilter{
+		CacheDir:       t.TempDir(),
+		Logger:         zerolog.Nop(),
+		GitCredentials: GitCredentials{AuthMethod: AuthMethodNone},
+		CachePolicy:    CachePolicyAlways,
+		LockMode:       LockModeUpdate,
+	}
+
+	output, err := f.Filter([]*yaml.RNode{yaml.MustParse(clusterPackages)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := output[len(output)-1]
+
+	// Move the branch without changing the package contents.
+	files["other.txt"] = "other"
+	moved := commitTestFiles(t, upstream, files)
+
+	f.LockMode = LockModeVerify
+	_, err = f.Filter([]*yaml.RNode{yaml.MustParse(clusterPackages), lock})
+	if err == nil || !strings.Contains(err.Error(), "package a ref master resolved to commit "+moved.String()) {
+		t.Fatalf("expected commit mismatch error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "digest") {
+		t.Fatalf("expected package digest to match, got %v", err)
+	}
+}
+
+func TestAuthBasicAuth(t *testing.T) {
+	var tests = []struct {
+		name       string
+		authMethod AuthMethod
+		repo       string
+		err        string
+	}{
+		{name: "https-token", authMethod: AuthMethodHTTPSToken, repo: "https://github.com/seek-oss/kpt-functions"},
+		{name: "basic-auth", authMethod: AuthMethodBasicAuth, repo: "https://github.com/seek-oss/kpt-functions"},
+		{
+			name:       "https-token-ssh-scheme",
+			authMethod: AuthMethodHTTPSToken,
+			repo:       "ssh://git@github.com/seek-oss/kpt-functions",
+			err:        "got invalid scheme ssh for httpsToken authentication, use https scheme instead",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &ClusterPackagesFilter{
+				GitCredentials: GitCredentials{AuthMethod: test.authMethod, GitUsername: "user", GitPassword: "secret"},
+			}
+
+			auth, err := f.auth(test.repo)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			basic, ok := auth.(*http.BasicAuth)
+			if !ok {
+				t.Fatalf("expected *http.BasicAuth, got %T", auth)
+			}
+			if basic.Username != "user" || basic.Password != "secret" {
+				t.Errorf("unexpected credentials %s:%s", basic.Username, basic.Password)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der := x509.MarshalPKCS1PrivateKey(key)
+	plain := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der})
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", der, []byte("passphrase"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := pem.EncodeToMemory(block)
+
+	var tests = []struct {
+		name       string
+		key        []byte
+		passphrase string
+		err        string
+	}{
+		{name: "unencrypted", key: plain},
+		{name: "encrypted", key: encrypted, passphrase: "passphrase"},
+		{
+			name: "encrypted-no-passphrase",
+			key:  encrypted,
+			err:  "Git private key is encrypted but no passphrase was configured",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parsePrivateKey(test.key, test.passphrase)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
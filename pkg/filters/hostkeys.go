@@ -0,0 +1,104 @@
+package filters
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
+
+// KnownHostsEnvVar defines the name of the environment variable that overrides the default known_hosts files. It
+// contains a list of files separated by the OS path list separator.
+const KnownHostsEnvVar = "SSH_KNOWN_HOSTS"
+
+// hostKeyCallback returns the callback used to verify the host keys of SSH Git servers. Hosts with pinned
+// fingerprints are only verified against those fingerprints, while all other hosts are verified against the default
+// known_hosts files and the configured KnownHostsFiles.
+func (f *ClusterPackagesFilter) hostKeyCallback() (cryptossh.HostKeyCallback, error) {
+	if f.InsecureIgnoreHostKey {
+		return cryptossh.InsecureIgnoreHostKey(), nil
+	}
+
+	files, err := defaultKnownHostsFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range f.KnownHostsFiles {
+		if _, err := os.Stat(file); err != nil {
+			return nil, errors.WrapPrefixf(err, "error reading known_hosts file %s", file)
+		}
+		files = append(files, file)
+	}
+
+	return newHostKeyCallback(files, f.HostKeyFingerprints)
+}
+
+// defaultKnownHostsFiles returns the existing known_hosts files that are used by default. These are the files
+// listed in the KnownHostsEnvVar environment variable if it is set, otherwise ~/.ssh/known_hosts and
+// /etc/ssh/ssh_known_hosts.
+func defaultKnownHostsFiles() ([]string, error) {
+	candidates := filepath.SplitList(os.Getenv(KnownHostsEnvVar))
+	if len(candidates) == 0 {
+		home, err := homedir.Dir()
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "error finding home directory")
+		}
+		candidates = []string{filepath.Join(home, ".ssh", "known_hosts"), "/etc/ssh/ssh_known_hosts"}
+	}
+
+	var files []string
+	for _, file := range candidates {
+		if _, err := os.Stat(file); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.WrapPrefixf(err, "error reading known_hosts file %s", file)
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// newHostKeyCallback returns a callback that verifies host keys against the specified pinned SHA256 fingerprints,
+// keyed by host name, and the specified known_hosts files.
+func newHostKeyCallback(files []string, fingerprints map[string][]string) (cryptossh.HostKeyCallback, error) {
+	known, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, errors.WrapPrefixf(err, "error reading known_hosts files")
+	}
+
+	return func(hostname string, remote net.Addr, key cryptossh.PublicKey) error {
+		fingerprint := cryptossh.FingerprintSHA256(key)
+
+		host := hostname
+		if h, _, err := net.SplitHostPort(hostname); err == nil {
+			host = h
+		}
+
+		if pinned, ok := fingerprints[host]; ok {
+			for _, p := range pinned {
+				if p == fingerprint {
+					return nil
+				}
+			}
+			return errors.Errorf("host key %s for %s does not match any of its pinned fingerprints", fingerprint, host)
+		}
+
+		err := known(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); ok {
+			if len(keyErr.Want) == 0 {
+				return errors.Errorf("unknown host key %s for %s, add it to a known_hosts file or pin its fingerprint",
+					fingerprint, host)
+			}
+			return errors.Errorf("host key %s for %s does not match its known_hosts entry", fingerprint, host)
+		}
+
+		return err
+	}, nil
+}
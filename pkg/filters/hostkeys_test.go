@@ -0,0 +1,88 @@
+package filters
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) cryptossh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := cryptossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	known := newTestHostKey(t)
+	pinned := newTestHostKey(t)
+	other := newTestHostKey(t)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{"git.example.com"}, known)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := newHostKeyCallback([]string{knownHosts}, map[string][]string{
+		"pinned.example.com": {cryptossh.FingerprintSHA256(pinned)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name string
+		host string
+		key  cryptossh.PublicKey
+		err  string
+	}{
+		{name: "known", host: "git.example.com:22", key: known},
+		{name: "pinned", host: "pinned.example.com:22", key: pinned},
+		{
+			name: "known-mismatch",
+			host: "git.example.com:22",
+			key:  other,
+			err:  "host key " + cryptossh.FingerprintSHA256(other) + " for git.example.com does not match its known_hosts entry",
+		},
+		{
+			name: "pinned-mismatch",
+			host: "pinned.example.com:22",
+			key:  known,
+			err:  "host key " + cryptossh.FingerprintSHA256(known) + " for pinned.example.com does not match any of its pinned fingerprints",
+		},
+		{
+			name: "unknown",
+			host: "unknown.example.com:22",
+			key:  known,
+			err:  "unknown host key " + cryptossh.FingerprintSHA256(known) + " for unknown.example.com, add it to a known_hosts file or pin its fingerprint",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := callback(test.host, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}, test.key)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -1,13 +1,126 @@
 package filters
 
 import (
+	"path"
+	"sort"
+	"strings"
+
 	"github.com/GoogleContainerTools/kpt/pkg/kptfile"
+	"sigs.k8s.io/kustomize/kyaml/errors"
 	"sigs.k8s.io/kustomize/kyaml/fieldmeta"
 	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
 	"sigs.k8s.io/kustomize/kyaml/openapi"
+	"sigs.k8s.io/kustomize/kyaml/setters2"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
+// subpackage groups the Kptfile of a Kpt package or subpackage with the resources that it owns.
+type subpackage struct {
+	// dir is the directory of the Kptfile, according to its config.kubernetes.io/path annotation.
+	dir string
+	// kptfile is the Kptfile resource node.
+	kptfile *yaml.RNode
+	// resources are the resource nodes, other than Kptfiles, that are owned by the Kptfile.
+	resources []*yaml.RNode
+	// parent is the subpackage that contains this subpackage, if any.
+	parent *subpackage
+}
+
+// groupSubpackages groups the specified resource nodes by the Kptfile that owns them, which is the Kptfile in the
+// nearest directory that contains them according to their config.kubernetes.io/path annotations. Nodes without the
+// annotation are owned by the Kptfile in the root of the package, and a package with a single Kptfile owns all of
+// its resources regardless of their directories. Subpackages are returned in order of depth, so that
+// parents precede their children.
+func groupSubpackages(nodes []*yaml.RNode) ([]*subpackage, error) {
+	kptfileNodes, err := KptfileFilter().Filter(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kptfileNodes) == 0 {
+		return nil, errors.Errorf("expected a single Kptfile in package but got 0")
+	}
+
+	byDir := map[string]*subpackage{}
+	var packages []*subpackage
+	for _, node := range kptfileNodes {
+		dir := nodeDir(node)
+		if _, ok := byDir[dir]; ok {
+			return nil, errors.Errorf("expected a single Kptfile in package directory %s but got more than one", dir)
+		}
+		byDir[dir] = &subpackage{dir: dir, kptfile: node}
+		packages = append(packages, byDir[dir])
+	}
+
+	sort.SliceStable(packages, func(i, j int) bool {
+		return dirDepth(packages[i].dir) < dirDepth(packages[j].dir)
+	})
+
+	for _, p := range packages {
+		if p.dir != "." {
+			p.parent = owningSubpackage(byDir, path.Dir(p.dir))
+		}
+	}
+
+	for _, node := range nodes {
+		if isKptfile(node) {
+			continue
+		}
+
+		owner := owningSubpackage(byDir, nodeDir(node))
+		if owner == nil && len(packages) == 1 {
+			owner = packages[0]
+		}
+		if owner == nil {
+			meta, _ := node.GetMeta()
+			return nil, errors.Errorf("%s %s in %s is not in a Kpt package", meta.Kind, meta.Name,
+				meta.Annotations[kioutil.PathAnnotation])
+		}
+		owner.resources = append(owner.resources, node)
+	}
+
+	return packages, nil
+}
+
+// owningSubpackage returns the subpackage of the nearest of the specified directory and its parents, or nil if there
+// is none.
+func owningSubpackage(byDir map[string]*subpackage, dir string) *subpackage {
+	for {
+		if p, ok := byDir[dir]; ok {
+			return p
+		}
+		if dir == "." || dir == "/" {
+			return nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// nodeDir returns the directory of the specified resource node according to its config.kubernetes.io/path
+// annotation, or "." if it has none.
+func nodeDir(node *yaml.RNode) string {
+	meta, err := node.GetMeta()
+	if err != nil {
+		return "."
+	}
+
+	return path.Dir(path.Clean(meta.Annotations[kioutil.PathAnnotation]))
+}
+
+// dirDepth returns the number of directories in the specified relative directory path.
+func dirDepth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+
+	return strings.Count(dir, "/") + 1
+}
+
+// kptfileV1TypeMeta defines the type metadata of kpt v1 Kptfiles, which declare setters in the configs of the
+// apply-setters functions in their pipelines rather than as OpenAPI definitions.
+var kptfileV1TypeMeta = yaml.TypeMeta{APIVersion: "kpt.dev/v1", Kind: kptfile.KptFileName}
+
 // KptfileFilter provides a kio.Filter that returns only resource nodes that correspond to Kptfiles.
 func KptfileFilter() kio.Filter {
 	return findAll(isKptfile)
@@ -18,14 +131,24 @@ func NotKptfileFilter() kio.Filter {
 	return findAll(isNotKptfile)
 }
 
-// isKptfile returns true if the specified node is a Kptfile, false otherwise.
+// isKptfile returns true if the specified node is a kpt v1alpha1 or v1 Kptfile, false otherwise.
 func isKptfile(node *yaml.RNode) bool {
 	meta, err := node.GetMeta()
 	if err != nil {
 		return false
 	}
 
-	return meta.TypeMeta == kptfile.TypeMeta.TypeMeta
+	return meta.TypeMeta == kptfile.TypeMeta.TypeMeta || meta.TypeMeta == kptfileV1TypeMeta
+}
+
+// isKptfileV1 returns true if the specified node is a kpt v1 Kptfile, false otherwise.
+func isKptfileV1(node *yaml.RNode) bool {
+	meta, err := node.GetMeta()
+	if err != nil {
+		return false
+	}
+
+	return meta.TypeMeta == kptfileV1TypeMeta
 }
 
 // isNotKptfile returns true if the specified node is not a Kptfile, false otherwise.
@@ -45,6 +168,47 @@ func hasKptfileSetter(node *yaml.RNode, name string) bool {
 	return oa != nil
 }
 
+// kptfileSetter returns the definition of the setter with the specified name in the specified resource node (that is
+// assumed to pertain to a Kptfile), or nil if it does not contain the setter.
+func kptfileSetter(node *yaml.RNode, name string) (*setters2.SetterDefinition, error) {
+	key := fieldmeta.SetterDefinitionPrefix + name
+	def, err := node.Pipe(yaml.Lookup(openapi.SupplementaryOpenAPIFieldName, openapi.Definitions, key,
+		setters2.K8sCliExtensionKey, "setter"))
+	if err != nil || def == nil {
+		return nil, err
+	}
+
+	var setter setters2.SetterDefinition
+	if err := def.Document().Decode(&setter); err != nil {
+		return nil, errors.WrapPrefixf(err, "could not decode setter %s", name)
+	}
+
+	return &setter, nil
+}
+
+// setterNames returns the names of the setters defined in the specified resource node (that is assumed to pertain to
+// a Kptfile).
+func setterNames(node *yaml.RNode) ([]string, error) {
+	definitions, err := node.Pipe(yaml.Lookup(openapi.SupplementaryOpenAPIFieldName, openapi.Definitions))
+	if err != nil || definitions == nil {
+		return nil, err
+	}
+
+	keys, err := definitions.Fields()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, fieldmeta.SetterDefinitionPrefix) {
+			names = append(names, strings.TrimPrefix(key, fieldmeta.SetterDefinitionPrefix))
+		}
+	}
+
+	return names, nil
+}
+
 // findAll returns a kio.Filter that include/excludes based on the specified predicate.
 func findAll(p func(*yaml.RNode) bool) kio.Filter {
 	return kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
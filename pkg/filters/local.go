@@ -0,0 +1,43 @@
+package filters
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// fileProtocol defines the transport protocol used for file:// URLs and plain filesystem paths.
+const fileProtocol = "file"
+
+// InstallLocalTransport replaces go-git's transport for file:// URLs and plain filesystem paths with one that serves
+// local repositories in-process. go-git's own file transport runs the git-upload-pack binary, which is not installed
+// in the function image. The transport is registered process-wide, so it is installed by the function rather than
+// by this package.
+func InstallLocalTransport() {
+	client.InstallProtocol(fileProtocol, server.NewServer(localRepositoryLoader{}))
+}
+
+// localRepositoryLoader implements server.Loader for bare and non-bare repositories on the local filesystem.
+type localRepositoryLoader struct{}
+
+// Load implements server.Loader.Load.
+func (localRepositoryLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	repo, err := git.PlainOpen(ep.Path)
+	if err == git.ErrRepositoryNotExists {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.Storer, nil
+}
+
+// isLocalRepository returns whether the specified repository URI refers to a repository on the local filesystem,
+// either as a file:// URL or as a plain path.
+func isLocalRepository(repoURL string) bool {
+	ep, err := transport.NewEndpoint(repoURL)
+	return err == nil && ep.Protocol == fileProtocol
+}
@@ -0,0 +1,86 @@
+package filters
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestMain(m *testing.M) {
+	InstallLocalTransport()
+	os.Exit(m.Run())
+}
+
+func TestClusterPackagesFilterLocalRepositories(t *testing.T) {
+	upstreamDir, upstream, _ := newTestRepository(t, 0)
+	commitTestFiles(t, upstream, map[string]string{
+		"pkg/Kptfile": "apiVersion: kpt.dev/v1alpha1\nkind: Kptfile\nmetadata:\n  name: pkg\n",
+		"pkg/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n",
+	})
+
+	bareDir := filepath.Join(t.TempDir(), "packages.git")
+	if _, err := git.PlainClone(bareDir, true, &git.CloneOptions{URL: upstreamDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name string
+		repo string
+	}{
+		{name: "path", repo: upstreamDir},
+		{name: "file-url", repo: "file://" + filepath.ToSlash(upstreamDir)},
+		{name: "bare-path", repo: bareDir},
+		{name: "bare-file-url", repo: "file://" + filepath.ToSlash(bareDir)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := yaml.Parse(`
+apiVersion: kpt.seek.com/v1alpha1
+kind: ClusterPackages
+metadata:
+  name: cluster
+spec:
+  baseDir: out
+  packages:
+  - name: a
+    git: {repo: "` + test.repo + `", directory: pkg, ref: master}
+`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Key file auth is configured to check that local repositories never require authentication.
+			f := &ClusterPackagesFilter{
+				CacheDir:       t.TempDir(),
+				Logger:         zerolog.Nop(),
+				GitCredentials: GitCredentials{AuthMethod: AuthMethodKeyFile},
+			}
+
+			output, err := f.Filter([]*yaml.RNode{input})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var actual []string
+			for _, node := range output {
+				meta, err := node.GetMeta()
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual = append(actual, meta.Annotations[kioutil.PathAnnotation])
+			}
+
+			expected := []string{"out/a/Kptfile", "out/a/cm.yaml"}
+			if strings.Join(actual, ",") != strings.Join(expected, ",") {
+				t.Fatalf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package filters
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+)
+
+// LocalPathBase defines what the directories of local packages and the files of patches are resolved relative to.
+type LocalPathBase string
+
+const (
+	// LocalPathBaseClusterPackages resolves local package directories and patch files relative to the directory of the
+	// file that declares the ClusterPackages resource, as recorded by its config.kubernetes.io/path annotation.
+	LocalPathBaseClusterPackages LocalPathBase = "clusterPackages"
+	// LocalPathBaseWorkdir resolves local package directories and patch files relative to the working directory of
+	// the function.
+	LocalPathBaseWorkdir LocalPathBase = "workdir"
+)
+
+// localPackageDir returns the directory of the specified local package of the specified ClusterPackages resource.
+// An error is returned if the directory is outside of the source directory.
+func (f *ClusterPackagesFilter) localPackageDir(res *ClusterPackages, pkg *Package) (string, error) {
+	return f.sourcePath(res, pkg.Local.Directory, fmt.Sprintf("local package %s directory", pkg.Name))
+}
+
+// sourcePath resolves the specified path, which is declared by the specified ClusterPackages resource, according to
+// the LocalPathBase. An error that refers to the path by the specified description is returned if the path is
+// outside of the source directory.
+func (f *ClusterPackagesFilter) sourcePath(res *ClusterPackages, p, description string) (string, error) {
+	sourceDir, err := f.sourceDir()
+	if err != nil {
+		return "", err
+	}
+
+	base := sourceDir
+	switch f.localPathBase() {
+	case LocalPathBaseWorkdir:
+		base, err = os.Getwd()
+		if err != nil {
+			return "", errors.WrapPrefixf(err, "error getting workdir")
+		}
+	default:
+		if annotation := res.Annotations[kioutil.PathAnnotation]; annotation != "" {
+			base = filepath.Join(sourceDir, filepath.FromSlash(path.Dir(annotation)))
+		}
+	}
+
+	resolved := filepath.FromSlash(p)
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(base, resolved)
+	}
+
+	if !isWithinDir(resolved, sourceDir) {
+		return "", errors.Errorf("%s %s is outside the source directory %s", description, p, sourceDir)
+	}
+
+	return resolved, nil
+}
+
+// sourceDir returns the absolute path of the configured SourceDir, defaulting to the working directory.
+func (f *ClusterPackagesFilter) sourceDir() (string, error) {
+	if f.SourceDir == "" {
+		workdir, err := os.Getwd()
+		if err != nil {
+			return "", errors.WrapPrefixf(err, "error getting workdir")
+		}
+		return workdir, nil
+	}
+
+	dir, err := filepath.Abs(f.SourceDir)
+	if err != nil {
+		return "", errors.WrapPrefixf(err, "error resolving source directory %s", f.SourceDir)
+	}
+
+	return dir, nil
+}
+
+// localPathBase returns the configured LocalPathBase, defaulting to LocalPathBaseClusterPackages.
+func (f *ClusterPackagesFilter) localPathBase() LocalPathBase {
+	if f.LocalPathBase == "" {
+		return LocalPathBaseClusterPackages
+	}
+
+	return f.LocalPathBase
+}
+
+// isWithinDir returns whether the specified path is the specified directory or is inside it. Symlinks are resolved
+// where possible, so that a symlink inside the directory that points outside of it is not considered to be inside.
+func isWithinDir(p, dir string) bool {
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		p = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,41 @@
+package filters
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsWithinDir(t *testing.T) {
+	root := t.TempDir()
+	sourceDir := filepath.Join(root, "source")
+	outsideDir := filepath.Join(root, "outside")
+	for _, dir := range []string{filepath.Join(sourceDir, "packages", "a"), outsideDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outsideDir, filepath.Join(sourceDir, "link")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+
+	var tests = []struct {
+		path     string
+		expected bool
+	}{
+		{path: sourceDir, expected: true},
+		{path: filepath.Join(sourceDir, "packages", "a"), expected: true},
+		{path: filepath.Join(sourceDir, "packages", "missing"), expected: true},
+		{path: filepath.Join(sourceDir, "packages", "..", "..", "outside")},
+		{path: filepath.Join(root, "source2")},
+		{path: filepath.Join(sourceDir, "link")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if actual := isWithinDir(test.path, sourceDir); actual != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package filters
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GoogleContainerTools/kpt/pkg/kptfile"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// ClusterPackagesLockKind defines the kind used by the ClusterPackagesLock resource.
+	ClusterPackagesLockKind = "ClusterPackagesLock"
+
+	// lockFileSuffix defines the suffix that replaces the extension of a ClusterPackages file to form the name
+	// of its lock file, e.g. packages.yaml is locked by packages.lock.yaml.
+	lockFileSuffix = ".lock.yaml"
+	// defaultLockFileName defines the name of the lock file used when a ClusterPackages resource has no path.
+	defaultLockFileName = "packages" + lockFileSuffix
+
+	// digestPrefix defines the algorithm prefix of package content digests.
+	digestPrefix = "sha256:"
+)
+
+// LockMode defines how the ClusterPackagesFilter uses ClusterPackagesLock resources.
+type LockMode string
+
+const (
+	// LockModeNone disables lock files. ClusterPackagesLock resources are passed through untouched.
+	LockModeNone LockMode = "none"
+	// LockModeUpdate emits a ClusterPackagesLock resource for every ClusterPackages resource, replacing any
+	// existing lock.
+	LockModeUpdate LockMode = "update"
+	// LockModeVerify requires an existing ClusterPackagesLock resource for every ClusterPackages resource and
+	// refuses to render packages when the lock and the spec disagree.
+	LockModeVerify LockMode = "verify"
+)
+
+// ClusterPackagesLock records exactly which commit and package contents were used to render each package of a
+// ClusterPackages resource.
+type ClusterPackagesLock struct {
+	// Standard Kubernetes metadata. The name matches the name of the locked ClusterPackages resource.
+	yaml.ResourceMeta `json:",inline" yaml:",inline"`
+	// Spec provides the resource specification.
+	Spec ClusterPackagesLockSpec `yaml:"spec,omitempty"`
+}
+
+// ClusterPackagesLockSpec defines the main body of the ClusterPackagesLock resource.
+type ClusterPackagesLockSpec struct {
+	// Packages specifies the locked packages, in the order they are declared in the ClusterPackages resource.
+	Packages []LockedPackage `yaml:"packages,omitempty"`
+}
+
+// LockedPackage defines the locked state of a single package.
+type LockedPackage struct {
+	// Name specifies the name of the package.
+	Name string `yaml:"name,omitempty"`
+	// Git specifies the requested repository, directory and ref of the package, and the commit the ref resolved to.
+	Git kptfile.Git `yaml:"git,omitempty"`
+	// Local specifies the location of a local package.
+	Local LocalPackage `yaml:"local,omitempty"`
+	// Digest specifies the digest of the fetched package contents, before any variables are applied.
+	Digest string `yaml:"digest,omitempty"`
+}
+
+// isClusterPackagesLock returns whether the specified resource metadata pertains to a ClusterPackagesLock resource.
+func isClusterPackagesLock(meta yaml.ResourceMeta) bool {
+	return meta.APIVersion == ClusterPackagesAPIVersion && meta.Kind == ClusterPackagesLockKind
+}
+
+// lockPath returns the path of the lock file for a ClusterPackages resource with the specified path.
+func lockPath(clusterPackagesPath string) string {
+	if clusterPackagesPath == "" {
+		return defaultLockFileName
+	}
+
+	return strings.TrimSuffix(clusterPackagesPath, path.Ext(clusterPackagesPath)) + lockFileSuffix
+}
+
+// lockKey returns the key that identifies the lock at the specified path of the ClusterPackages resource with the
+// specified name. Locks are identified by both, as ClusterPackages resources in different files may share a name
+// and ClusterPackages resources in the same file share a lock file.
+func lockKey(lockFilePath, name string) string {
+	if lockFilePath == "" {
+		lockFilePath = defaultLockFileName
+	}
+
+	return path.Clean(lockFilePath) + ":" + name
+}
+
+// clusterPackagesLockKey returns the key that identifies the lock of the specified ClusterPackages resource.
+func clusterPackagesLockKey(res *ClusterPackages) string {
+	return lockKey(lockPath(res.Annotations[kioutil.PathAnnotation]), res.Name)
+}
+
+// newLockedPackage returns the LockedPackage describing the specified package as it is declared in the spec.
+func newLockedPackage(pkg *Package) LockedPackage {
+	locked := LockedPackage{Name: pkg.Name, Local: pkg.Local}
+	if pkg.Local.Directory == "" {
+		locked.Git = kptfile.Git{Repo: pkg.Git.Repo, Directory: pkg.Git.Directory, Ref: pkg.Git.Ref}
+	}
+
+	return locked
+}
+
+// newLockNode returns the resource node for the lock of the specified ClusterPackages resource.
+func newLockNode(res *ClusterPackages, packages []LockedPackage) (*yaml.RNode, error) {
+	lock := ClusterPackagesLock{
+		ResourceMeta: yaml.ResourceMeta{
+			TypeMeta: yaml.TypeMeta{APIVersion: ClusterPackagesAPIVersion, Kind: ClusterPackagesLockKind},
+			ObjectMeta: yaml.ObjectMeta{
+				NameMeta: yaml.NameMeta{Name: res.Name},
+				Annotations: map[string]string{
+					kioutil.PathAnnotation: lockPath(res.Annotations[kioutil.PathAnnotation]),
+				},
+			},
+		},
+		Spec: ClusterPackagesLockSpec{Packages: packages},
+	}
+
+	b, err := yaml.Marshal(lock)
+	if err != nil {
+		return nil, errors.WrapPrefixf(err, "could not marshal lock for %s", res.Name)
+	}
+
+	return yaml.Parse(string(b))
+}
+
+// verifySpec returns a description of every way in which the specified ClusterPackages spec disagrees with
+// its lock.
+func verifySpec(res *ClusterPackages, lock *ClusterPackagesLock) []string {
+	var problems []string
+
+	locked := map[string]LockedPackage{}
+	for _, p := range lock.Spec.Packages {
+		locked[p.Name] = p
+	}
+
+	declared := map[string]bool{}
+	for i := range res.Spec.Packages {
+		pkg := &res.Spec.Packages[i]
+		declared[pkg.Name] = true
+
+		l, ok := locked[pkg.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("package %s is not locked", pkg.Name))
+			continue
+		}
+
+		expected := newLockedPackage(pkg)
+		if l.Local != expected.Local {
+			problems = append(problems, fmt.Sprintf("package %s local directory is %q but locked as %q",
+				pkg.Name, expected.Local.Directory, l.Local.Directory))
+		}
+		if l.Git.Repo != expected.Git.Repo {
+			problems = append(problems, fmt.Sprintf("package %s repo is %q but locked as %q",
+				pkg.Name, expected.Git.Repo, l.Git.Repo))
+		}
+		if l.Git.Directory != expected.Git.Directory {
+			problems = append(problems, fmt.Sprintf("package %s directory is %q but locked as %q",
+				pkg.Name, expected.Git.Directory, l.Git.Directory))
+		}
+		if l.Git.Ref != expected.Git.Ref {
+			problems = append(problems, fmt.Sprintf("package %s ref is %q but locked as %q",
+				pkg.Name, expected.Git.Ref, l.Git.Ref))
+		}
+	}
+
+	for _, p := range lock.Spec.Packages {
+		if !declared[p.Name] {
+			problems = append(problems, fmt.Sprintf("package %s is locked but not declared", p.Name))
+		}
+	}
+
+	return problems
+}
+
+// verifyFetched returns a description of every way in which the fetched packages disagree with their lock.
+func verifyFetched(fetched []LockedPackage, lock *ClusterPackagesLock) []string {
+	var problems []string
+
+	locked := map[string]LockedPackage{}
+	for _, p := range lock.Spec.Packages {
+		locked[p.Name] = p
+	}
+
+	for _, p := range fetched {
+		l := locked[p.Name]
+		if l.Git.Commit != p.Git.Commit {
+			problems = append(problems, fmt.Sprintf("package %s ref %s resolved to commit %s but is locked to %s",
+				p.Name, p.Git.Ref, p.Git.Commit, l.Git.Commit))
+		}
+		if l.Digest != p.Digest {
+			problems = append(problems, fmt.Sprintf("package %s contents have digest %s but are locked to %s",
+				p.Name, p.Digest, l.Digest))
+		}
+	}
+
+	return problems
+}
+
+// digestDirectory returns the digest of the contents of all files under the specified directory. The digest
+// covers the relative path and contents of each file, so it changes if files are added, removed or renamed.
+func digestDirectory(dir string) (string, error) {
+	var files []string
+	if err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	}); err != nil {
+		return "", errors.WrapPrefixf(err, "error listing files in %s", dir)
+	}
+
+	sort.Strings(files)
+
+	hash := sha256.New()
+	for _, p := range files {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return "", err
+		}
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return "", errors.WrapPrefixf(err, "error reading %s", p)
+		}
+
+		fmt.Fprintf(hash, "%s\x00%d\x00", filepath.ToSlash(rel), len(b))
+		hash.Write(b)
+	}
+
+	return digestPrefix + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// problemsError returns an error that lists all of the specified problems.
+func problemsError(summary string, problems []string) error {
+	return errors.Errorf("%s:\n  - %s", summary, strings.Join(problems, "\n  - "))
+}
@@ -0,0 +1,93 @@
+package filters
+
+import (
+	"context"
+	"strings"
+)
+
+// anonymousRemoteName defines the name go-git requires for remotes that are not saved in the repository config.
+const anonymousRemoteName = "anonymous"
+
+// URLRewrite rewrites repository URLs that start with a prefix, in the same way as git's url.<base>.insteadOf
+// configuration.
+type URLRewrite struct {
+	// InsteadOf specifies the prefix of the repository URLs that are rewritten.
+	InsteadOf string `yaml:"insteadOf"`
+	// Base specifies the prefix that replaces InsteadOf.
+	Base string `yaml:"base"`
+}
+
+// rewrite returns the specified repository URL rewritten by the rule with the longest matching prefix, and whether
+// any rule matched.
+func rewrite(repoURL string, rewrites []URLRewrite) (string, bool) {
+	var match *URLRewrite
+	for i := range rewrites {
+		r := &rewrites[i]
+		if strings.HasPrefix(repoURL, r.InsteadOf) && (match == nil || len(r.InsteadOf) > len(match.InsteadOf)) {
+			match = r
+		}
+	}
+
+	if match == nil {
+		return repoURL, false
+	}
+
+	return match.Base + strings.TrimPrefix(repoURL, match.InsteadOf), true
+}
+
+// remoteURLs returns the URLs that the specified repository is cloned or fetched from, in the order they are tried.
+// The first URL is the repository URL rewritten by the URLRewrites, followed by the repository URL rewritten by each
+// matching mirror in turn.
+func (f *ClusterPackagesFilter) remoteURLs(repoURL string) []string {
+	primary, _ := rewrite(repoURL, f.URLRewrites)
+	urls := []string{primary}
+	seen := map[string]bool{primary: true}
+
+	for _, mirror := range f.Mirrors {
+		if u, ok := rewrite(repoURL, []URLRewrite{mirror}); ok && !seen[u] {
+			urls = append(urls, u)
+			seen[u] = true
+		}
+	}
+
+	return urls
+}
+
+// describeRemote returns a description of the specified repository for use in logs and errors that includes the
+// remote URL it was accessed through, if that differs from the repository URL.
+func describeRemote(repoURL, remoteURL string) string {
+	if repoURL == remoteURL {
+		return repoURL
+	}
+
+	return repoURL + " (via " + remoteURL + ")"
+}
+
+// withRemotes invokes fn with each remote URL of the specified repository in turn until it succeeds, and returns
+// the error of the last attempt if none succeed. Transient failures are retried before falling back to the next
+// remote URL.
+func (f *ClusterPackagesFilter) withRemotes(ctx context.Context, repoURL string,
+	fn func(ctx context.Context, remoteURL string) error) error {
+	urls := f.remoteURLs(repoURL)
+	if f.Offline {
+		urls = localURLs(urls)
+		if len(urls) == 0 {
+			return errOffline(repoURL)
+		}
+	}
+
+	var err error
+	for i, u := range urls {
+		err = f.retry(ctx, "access "+describeRemote(repoURL, u), func(ctx context.Context) error {
+			return fn(ctx, u)
+		})
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if i < len(urls)-1 {
+			f.Logger.Warn().Err(err).Msgf("Could not access %s, falling back to %s", describeRemote(repoURL, u), urls[i+1])
+		}
+	}
+
+	return err
+}
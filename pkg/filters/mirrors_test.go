@@ -0,0 +1,104 @@
+package filters
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRemoteURLs(t *testing.T) {
+	f := &ClusterPackagesFilter{
+		URLRewrites: []URLRewrite{
+			{InsteadOf: "git@github.com:", Base: "https://github.com/"},
+			{InsteadOf: "git@github.com:seek-oss/", Base: "https://git.example.com/seek-oss/"},
+		},
+		Mirrors: []URLRewrite{
+			{InsteadOf: "git@github.com:seek-oss/", Base: "https://mirror-a.example.com/seek-oss/"},
+			{InsteadOf: "git@github.com:", Base: "https://git.example.com/"},
+			{InsteadOf: "git@github.com:", Base: "https://mirror-b.example.com/"},
+		},
+	}
+
+	var tests = []struct {
+		repo     string
+		expected []string
+	}{
+		{
+			repo: "git@github.com:seek-oss/packages.git",
+			expected: []string{
+				"https://git.example.com/seek-oss/packages.git",
+				"https://mirror-a.example.com/seek-oss/packages.git",
+				"https://mirror-b.example.com/seek-oss/packages.git",
+			},
+		},
+		{
+			repo: "git@github.com:other/packages.git",
+			expected: []string{
+				"https://github.com/other/packages.git",
+				"https://git.example.com/other/packages.git",
+				"https://mirror-b.example.com/other/packages.git",
+			},
+		},
+		{
+			repo:     "https://bitbucket.org/seek/packages.git",
+			expected: []string{"https://bitbucket.org/seek/packages.git"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repo, func(t *testing.T) {
+			actual := f.remoteURLs(test.repo)
+			if strings.Join(actual, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestOpenRepositoryMirrorFallback(t *testing.T) {
+	upstreamDir, _, hashes := newTestRepository(t, 1)
+	missingDir := filepath.Join(t.TempDir(), "missing")
+
+	// The repository URL is never accessed directly: it is rewritten to a missing repository, and falls back to
+	// the upstream repository through a mirror.
+	const repoURL = "git@github.com:seek-oss/packages.git"
+	f := &ClusterPackagesFilter{
+		CacheDir:    t.TempDir(),
+		Logger:      zerolog.Nop(),
+		URLRewrites: []URLRewrite{{InsteadOf: repoURL, Base: missingDir}},
+		Mirrors:     []URLRewrite{{InsteadOf: repoURL, Base: upstreamDir}},
+	}
+
+	ctx := context.Background()
+	repo, cloned, err := f.openRepository(ctx, repoURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cloned {
+		t.Fatal("expected repository to be cloned")
+	}
+
+	hash, err := resolveRef(repo, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hashes[0] {
+		t.Errorf("expected %s, got %s", hashes[0], hash)
+	}
+
+	// Fetching also falls back to the mirror.
+	if err := f.fetchRepository(ctx, repo, repoURL); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the mirror, the error identifies both the repository and the URL it was accessed through.
+	f.Mirrors = nil
+	err = f.fetchRepository(ctx, repo, repoURL)
+	expected := "error fetching Git repository " + repoURL + " (via " + missingDir + ")"
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected error starting with %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,139 @@
+package filters
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
+
+// checkCached returns an error listing every Git repository and ref referenced by the specified packages that
+// cannot be resolved from the cache without accessing the network. Local repositories are never reported.
+func (f *ClusterPackagesFilter) checkCached(jobs []packageJob) error {
+	var problems []string
+	for _, pkg := range gitPackages(jobs) {
+		if problem := f.checkCachedRef(pkg.Git.Repo, pkg.Git.Ref); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return problemsError("packages are not available in the cache in offline mode", problems)
+	}
+
+	return nil
+}
+
+// checkCachedRef returns a description of why the specified ref of the specified repository cannot be resolved from
+// the cache, or an empty string if it can.
+func (f *ClusterPackagesFilter) checkCachedRef(repoURL, ref string) string {
+	if !f.isOfflineRepository(repoURL) {
+		return ""
+	}
+
+	if f.Shallow && isShallowRef(ref) {
+		if _, err := f.resolveCachedRef(f.shallowRepositoryDir(repoURL, ref), ref); err == nil {
+			return ""
+		}
+	}
+
+	repoDir := f.repositoryDir(repoURL)
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		return fmt.Sprintf("repository %s is not cached", repoURL)
+	}
+
+	if _, err := f.resolveCachedRef(repoDir, ref); err != nil {
+		return fmt.Sprintf("ref %s of repository %s is not cached: %v", ref, repoURL, err)
+	}
+
+	return ""
+}
+
+// resolveCachedRef resolves the specified ref in the cached repository in the specified directory.
+func (f *ClusterPackagesFilter) resolveCachedRef(repoDir, ref string) (plumbing.Hash, error) {
+	unlock, err := f.lockRepository(repoDir, false)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer unlock()
+
+	return resolveRepositoryDirRef(repoDir, ref)
+}
+
+// resolveRepositoryDirRef resolves the specified ref in the repository in the specified directory. The lock on the
+// repository must be held.
+func resolveRepositoryDirRef(repoDir, ref string) (plumbing.Hash, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return resolveRef(repo, ref)
+}
+
+// warmCache clones or refreshes the cached repository of every Git repository and ref referenced by the specified
+// packages, according to the configured CachePolicy, without materialising or rendering any packages.
+func (f *ClusterPackagesFilter) warmCache(ctx context.Context, jobs []packageJob) error {
+	pkgs := gitPackages(jobs)
+	if err := parallelFor(ctx, f.Concurrency, len(pkgs), func(ctx context.Context, i int) error {
+		_, _, unlock, err := f.resolvePackage(ctx, pkgs[i])
+		if err != nil {
+			return err
+		}
+
+		unlock()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	f.Logger.Info().Msgf("Cached %d refs in %s", len(pkgs), f.CacheDir)
+	return nil
+}
+
+// gitPackages returns the Git packages of the specified jobs, omitting local packages and packages that refer to
+// the same repository and ref as an earlier package.
+func gitPackages(jobs []packageJob) []*Package {
+	var pkgs []*Package
+	seen := map[string]bool{}
+	for _, job := range jobs {
+		if job.pkg.Local.Directory != "" {
+			continue
+		}
+
+		key := job.pkg.Git.Repo + "\x00" + job.pkg.Git.Ref
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		pkgs = append(pkgs, job.pkg)
+	}
+
+	return pkgs
+}
+
+// isOfflineRepository returns whether the specified repository can only be read from the cache. This is the case for
+// every repository in offline mode, except those with a local remote URL, which are accessed without the network.
+func (f *ClusterPackagesFilter) isOfflineRepository(repoURL string) bool {
+	return f.Offline && len(localURLs(f.remoteURLs(repoURL))) == 0
+}
+
+// localURLs returns the specified remote URLs that refer to repositories on the local filesystem, in order.
+func localURLs(urls []string) []string {
+	var local []string
+	for _, u := range urls {
+		if isLocalRepository(u) {
+			local = append(local, u)
+		}
+	}
+
+	return local
+}
+
+// errOffline returns the error returned when the specified repository would be accessed in offline mode.
+func errOffline(repoURL string) error {
+	return errors.Errorf("cannot access Git repository %s in offline mode", repoURL)
+}
@@ -0,0 +1,151 @@
+package filters
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestClusterPackagesFilterOffline(t *testing.T) {
+	upstreamDir, upstream, _ := newTestRepository(t, 0)
+	commitTestFiles(t, upstream, map[string]string{
+		"pkg/Kptfile": "apiVersion: kpt.dev/v1alpha1\nkind: Kptfile\nmetadata:\n  name: pkg\n",
+		"pkg/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n",
+	})
+
+	localDir, local, _ := newTestRepository(t, 0)
+	commitTestFiles(t, local, map[string]string{
+		"pkg/Kptfile": "apiVersion: kpt.dev/v1alpha1\nkind: Kptfile\nmetadata:\n  name: pkg\n",
+	})
+
+	// Local repositories are accessed in offline mode, so the cached repositories are given remote URLs that are
+	// only rewritten to the local upstream repository while warming the cache.
+	const upstreamURL = "https://git.example.com/upstream.git"
+	const otherURL = "https://git.example.com/other.git"
+
+	newInput := func(t *testing.T, packages string) []*yaml.RNode {
+		node, err := yaml.Parse(`
+apiVersion: kpt.seek.com/v1alpha1
+kind: ClusterPackages
+metadata:
+  name: cluster
+spec:
+  baseDir: out
+  packages:
+` + packages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []*yaml.RNode{node}
+	}
+
+	cached := `
+  - name: a
+    git: {repo: "` + upstreamURL + `", directory: pkg, ref: master}
+`
+	cacheDir := t.TempDir()
+
+	// Warm the cache, which must not render anything.
+	warm := &ClusterPackagesFilter{
+		CacheDir:    cacheDir,
+		WarmCache:   true,
+		Logger:      zerolog.Nop(),
+		URLRewrites: []URLRewrite{{InsteadOf: upstreamURL, Base: upstreamDir}},
+	}
+	input := newInput(t, cached)
+	output, err := warm.Filter(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != 1 || output[0] != input[0] {
+		t.Fatalf("expected the input to be returned unchanged, got %d nodes", len(output))
+	}
+
+	// Make the upstream repository unavailable so that any attempt to access it fails.
+	if err := os.RemoveAll(upstreamDir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		f := &ClusterPackagesFilter{CacheDir: cacheDir, Offline: true, Logger: zerolog.Nop()}
+		output, err := f.Filter(newInput(t, cached))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(output) != 2 {
+			t.Fatalf("expected 2 nodes, got %d", len(output))
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		f := &ClusterPackagesFilter{CacheDir: cacheDir, Offline: true, Logger: zerolog.Nop()}
+		_, err := f.Filter(newInput(t, cached+`
+  - name: b
+    git: {repo: "`+upstreamURL+`", directory: pkg, ref: v2}
+  - name: c
+    git: {repo: "`+otherURL+`", directory: pkg, ref: master}
+  - name: d
+    git: {repo: "`+localDir+`", directory: pkg, ref: master}
+`))
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		for _, expected := range []string{
+			"ref v2 of repository " + upstreamURL + " is not cached",
+			"repository " + otherURL + " is not cached",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected error to contain %q, got %v", expected, err)
+			}
+		}
+		if strings.Contains(err.Error(), "ref master") {
+			t.Errorf("expected cached ref not to be reported, got %v", err)
+		}
+		if strings.Contains(err.Error(), localDir) {
+			t.Errorf("expected local repository not to be reported, got %v", err)
+		}
+
+		// Nothing must have been cloned.
+		if _, err := git.PlainOpen(f.repositoryDir(otherURL)); err == nil {
+			t.Errorf("expected %s not to be cloned", otherURL)
+		}
+	})
+
+	t.Run("local", func(t *testing.T) {
+		f := &ClusterPackagesFilter{CacheDir: cacheDir, Offline: true, Logger: zerolog.Nop()}
+		output, err := f.Filter(newInput(t, cached+`
+  - name: d
+    git: {repo: "`+localDir+`", directory: pkg, ref: master}
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(output) != 3 {
+			t.Fatalf("expected 3 nodes, got %d", len(output))
+		}
+	})
+
+	t.Run("local-rewrite", func(t *testing.T) {
+		f := &ClusterPackagesFilter{
+			CacheDir:    cacheDir,
+			Offline:     true,
+			Logger:      zerolog.Nop(),
+			URLRewrites: []URLRewrite{{InsteadOf: otherURL, Base: localDir}},
+		}
+		output, err := f.Filter(newInput(t, `
+  - name: c
+    git: {repo: "`+otherURL+`", directory: pkg, ref: master}
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(output) != 1 {
+			t.Fatalf("expected 1 node, got %d", len(output))
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package filters
+
+import (
+	"context"
+	"sync"
+)
+
+// parallelFor invokes fn for each index in [0, count) using at most concurrency goroutines. If any invocation
+// fails, the context passed to in-flight invocations is cancelled, no further invocations are started and the
+// error with the lowest index is returned.
+func parallelFor(ctx context.Context, concurrency, count int, fn func(ctx context.Context, i int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, count)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					errs[i] = err
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// waitContext invokes fn and waits until it returns or the context is done, whichever happens first. This bounds
+// operations that do not observe the cancellation of the context, such as SSH handshakes. If the context is done
+// first, its error is returned and fn continues to run in the background.
+func waitContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
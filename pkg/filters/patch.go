@@ -0,0 +1,295 @@
+package filters
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/kustomize/kyaml/yaml/merge2"
+)
+
+// Patch defines a patch that is applied to the resources of a package after its setters have been set and its
+// templates have been rendered. A patch whose content is a list is a JSON6902 patch, which requires a Target, and any
+// other patch is a strategic merge patch, which patches the resource with the kind, name and namespace of the patch
+// unless a Target is specified.
+type Patch struct {
+	// Patch specifies the content of the patch inline.
+	Patch string `yaml:"patch,omitempty"`
+	// Path specifies a file that contains the patch, relative to the directory of the file that declares the
+	// ClusterPackages resource unless the filter is configured to use LocalPathBaseWorkdir.
+	Path string `yaml:"path,omitempty"`
+	// Target selects the resources that the patch is applied to.
+	Target *ResourceSelector `yaml:"target,omitempty"`
+}
+
+// patchPackageNodes applies the patches of the specified package of the specified ClusterPackages resource to its
+// resources, in order. Kptfiles and PackageFile resources are never patched. An error is returned if a patch does
+// not match any resources.
+func (f *ClusterPackagesFilter) patchPackageNodes(
+	res *ClusterPackages, pkg *Package, nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	for i, patch := range pkg.Patches {
+		description := fmt.Sprintf("package %s patches[%d]", pkg.Name, i)
+
+		content, err := f.patchContent(res, patch, description)
+		if err != nil {
+			return nil, err
+		}
+
+		patchNode, err := yaml.Parse(content)
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "could not parse %s", description)
+		}
+
+		var apply func(node *yaml.RNode) (*yaml.RNode, error)
+		target := patch.Target
+		if patchNode.YNode().Kind == yaml.SequenceNode {
+			if target == nil {
+				return nil, errors.Errorf("%s is a JSON6902 patch and must specify a target", description)
+			}
+			if apply, err = json6902Patch(patchNode); err != nil {
+				return nil, errors.WrapPrefixf(err, "invalid %s", description)
+			}
+		} else {
+			if target == nil {
+				if target, err = strategicMergeTarget(patchNode); err != nil {
+					return nil, errors.WrapPrefixf(err, "%s", description)
+				}
+			}
+			apply = strategicMergePatch(patchNode)
+		}
+
+		var matched int
+		var patched []*yaml.RNode
+		for _, node := range nodes {
+			meta, err := node.GetMeta()
+			if err != nil {
+				return nil, err
+			}
+
+			if isKptfile(node) || isPackageFile(meta) || !target.matches(meta) {
+				patched = append(patched, node)
+				continue
+			}
+
+			matched++
+			result, err := apply(node)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not apply %s to %s %s", description, meta.Kind, meta.Name)
+			}
+			// A strategic merge patch with a $patch: delete directive removes the resource.
+			if result == nil {
+				f.Logger.Debug().Msgf("Deleted %s %s with %s", meta.Kind, meta.Name, description)
+				continue
+			}
+			patched = append(patched, result)
+		}
+
+		if matched == 0 {
+			return nil, errors.Errorf("%s does not match any resources", description)
+		}
+		nodes = patched
+	}
+
+	return nodes, nil
+}
+
+// patchContent returns the content of the specified patch, reading it from its file if it has a Path.
+func (f *ClusterPackagesFilter) patchContent(res *ClusterPackages, patch Patch, description string) (string, error) {
+	if patch.Path == "" {
+		return patch.Patch, nil
+	}
+
+	p, err := f.sourcePath(res, patch.Path, description+" path")
+	if err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", errors.WrapPrefixf(err, "could not read %s", description)
+	}
+
+	return string(b), nil
+}
+
+// strategicMergeTarget returns a selector for the resource with the kind, name and namespace of the specified
+// strategic merge patch.
+func strategicMergeTarget(patchNode *yaml.RNode) (*ResourceSelector, error) {
+	meta, err := patchNode.GetMeta()
+	if err != nil && err != yaml.ErrMissingMetadata {
+		return nil, err
+	}
+	if meta.Kind == "" || meta.Name == "" {
+		return nil, errors.Errorf("must specify a target or the kind and name of the resource that it patches")
+	}
+
+	return &ResourceSelector{Kind: meta.Kind, Name: meta.Name, Namespace: meta.Namespace}, nil
+}
+
+// strategicMergePatch returns a function that applies the specified strategic merge patch to a resource. The
+// function returns nil if the patch deletes the resource.
+func strategicMergePatch(patchNode *yaml.RNode) func(node *yaml.RNode) (*yaml.RNode, error) {
+	return func(node *yaml.RNode) (*yaml.RNode, error) {
+		return merge2.Merge(patchNode.Copy(), node, yaml.MergeOptions{
+			ListIncreaseDirection: yaml.MergeOptionsListAppend,
+		})
+	}
+}
+
+// json6902Patch returns a function that applies the specified JSON6902 patch operations to a resource.
+func json6902Patch(patchNode *yaml.RNode) (func(node *yaml.RNode) (*yaml.RNode, error), error) {
+	b, err := patchNode.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := jsonpatch.DecodePatch(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(node *yaml.RNode) (*yaml.RNode, error) {
+		doc, err := node.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		patched, err := ops.Apply(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		patchedNode, err := yaml.ConvertJSONToYamlNode(string(patched))
+		if err != nil {
+			return nil, err
+		}
+
+		// The patched JSON loses the comments and the key order of the resource, so it is merged back onto a copy
+		// of the resource rather than replacing it.
+		result := node.Copy()
+		syncNode(result.YNode(), patchedNode.YNode())
+		return result, nil
+	}, nil
+}
+
+// syncNode updates the specified destination node in-place so that it has the same content as the specified source
+// node, while retaining the comments, the key order and the styles of the destination where they still apply. Keys
+// that are only in the source are appended in source order.
+func syncNode(dst, src *yaml.Node) {
+	if dst.Kind == yaml.DocumentNode && len(dst.Content) == 1 {
+		dst = dst.Content[0]
+	}
+	if src.Kind == yaml.DocumentNode && len(src.Content) == 1 {
+		src = src.Content[0]
+	}
+
+	if dst.Kind != src.Kind {
+		dst.Kind, dst.Tag, dst.Value, dst.Style, dst.Content = src.Kind, src.Tag, src.Value, src.Style, src.Content
+		return
+	}
+
+	switch src.Kind {
+	case yaml.ScalarNode:
+		if dst.Value == src.Value && dst.ShortTag() == src.ShortTag() {
+			return
+		}
+		// The style is reset so that the value is only quoted if it would otherwise be read with another type.
+		dst.Tag, dst.Value, dst.Style = src.Tag, src.Value, 0
+
+	case yaml.MappingNode:
+		values := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			values[src.Content[i].Value] = src.Content[i+1]
+		}
+
+		var content []*yaml.Node
+		retained := map[string]bool{}
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			key, value := dst.Content[i], dst.Content[i+1]
+			if srcValue, ok := values[key.Value]; ok {
+				syncNode(value, srcValue)
+				content = append(content, key, value)
+				retained[key.Value] = true
+			}
+		}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			if !retained[src.Content[i].Value] {
+				content = append(content, src.Content[i], src.Content[i+1])
+			}
+		}
+		dst.Content = content
+
+	case yaml.SequenceNode:
+		// Elements are matched by identity rather than by index, so that adding or removing an element does not move
+		// the comments of the elements after it onto other elements. Elements that cannot be matched are only synced
+		// with the element at the same index if that element has not been matched either.
+		matches := make([]int, len(src.Content))
+		matched := make([]bool, len(dst.Content))
+		for i, item := range src.Content {
+			matches[i] = -1
+			for j, candidate := range dst.Content {
+				if !matched[j] && sameElement(candidate, item) {
+					matches[i], matched[j] = j, true
+					break
+				}
+			}
+		}
+		for i := range src.Content {
+			if matches[i] < 0 && i < len(dst.Content) && !matched[i] && dst.Content[i].Kind == src.Content[i].Kind &&
+				elementKey(dst.Content[i]) == "" && elementKey(src.Content[i]) == "" {
+				matches[i], matched[i] = i, true
+			}
+		}
+
+		content := make([]*yaml.Node, len(src.Content))
+		for i, item := range src.Content {
+			if matches[i] < 0 {
+				content[i] = item
+				continue
+			}
+			syncNode(dst.Content[matches[i]], item)
+			content[i] = dst.Content[matches[i]]
+		}
+		dst.Content = content
+	}
+}
+
+// sameElement returns whether the specified sequence elements are the same element, either because they have the
+// same key or because they are equal.
+func sameElement(a, b *yaml.Node) bool {
+	if key := elementKey(a); key != "" {
+		return key == elementKey(b)
+	}
+	return equalNodes(a, b)
+}
+
+// elementKey returns the key that identifies the specified sequence element, which is the value of its associative
+// key field for mappings, e.g. the name of a container, or empty if it has none.
+func elementKey(node *yaml.Node) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+
+	rn := yaml.NewRNode(node)
+	for _, field := range yaml.AssociativeSequenceKeys {
+		if f := rn.Field(field); f != nil && f.Value.YNode().Kind == yaml.ScalarNode {
+			return field + "=" + f.Value.YNode().Value
+		}
+	}
+	return ""
+}
+
+// equalNodes returns whether the specified nodes have the same values, ignoring comments and styles.
+func equalNodes(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Value != b.Value || a.ShortTag() != b.ShortTag() || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !equalNodes(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
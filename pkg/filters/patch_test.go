@@ -0,0 +1,224 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestPatchPackageNodes(t *testing.T) {
+	const input = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+data:
+  key: a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+  labels:
+    patch: "true"
+data:
+  key: b
+`
+
+	var tests = []struct {
+		name     string
+		patches  []Patch
+		expected string
+		err      string
+	}{
+		{
+			name: "strategic merge by name",
+			patches: []Patch{
+				{Patch: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\ndata:\n  other: value\n"},
+			},
+			expected: "a:key=a,other=value;b:key=b",
+		},
+		{
+			name: "strategic merge by target",
+			patches: []Patch{
+				{Patch: "data:\n  key: patched\n", Target: &ResourceSelector{Labels: map[string]string{"patch": "true"}}},
+			},
+			expected: "a:key=a;b:key=patched",
+		},
+		{
+			name: "strategic merge delete",
+			patches: []Patch{
+				{Patch: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n$patch: delete\n"},
+			},
+			expected: "a:key=a",
+		},
+		{
+			name: "json6902 in order",
+			patches: []Patch{
+				{Patch: "- op: add\n  path: /data/other\n  value: value\n", Target: &ResourceSelector{Kind: "ConfigMap"}},
+				{Patch: "- op: remove\n  path: /data/other\n", Target: &ResourceSelector{Name: "b"}},
+			},
+			expected: "a:key=a,other=value;b:key=b",
+		},
+		{
+			name:    "json6902 without target",
+			patches: []Patch{{Patch: "- op: remove\n  path: /data\n"}},
+			err:     "package sample patches[0] is a JSON6902 patch and must specify a target",
+		},
+		{
+			name:    "strategic merge without target or name",
+			patches: []Patch{{Patch: "data:\n  key: patched\n"}},
+			err:     "package sample patches[0]: must specify a target or the kind and name",
+		},
+		{
+			name:    "no match",
+			patches: []Patch{{Patch: "data: {}\n", Target: &ResourceSelector{Kind: "Secret"}}},
+			err:     "package sample patches[0] does not match any resources",
+		},
+		{
+			name:    "failed operation",
+			patches: []Patch{{Patch: "- op: remove\n  path: /missing\n", Target: &ResourceSelector{Name: "a"}}},
+			err:     "could not apply package sample patches[0] to ConfigMap a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodes, err := (&kio.ByteReader{Reader: strings.NewReader(input), OmitReaderAnnotations: true}).Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f := &ClusterPackagesFilter{Logger: zerolog.Nop()}
+			pkg := &Package{Name: "sample", Patches: test.patches}
+			nodes, err = f.patchPackageNodes(&ClusterPackages{}, pkg, nodes)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var actual []string
+			for _, node := range nodes {
+				meta, err := node.GetMeta()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				data := node.GetDataMap()
+				var values []string
+				for _, key := range []string{"key", "other"} {
+					if v, ok := data[key]; ok {
+						values = append(values, key+"="+v)
+					}
+				}
+				actual = append(actual, meta.Name+":"+strings.Join(values, ","))
+			}
+
+			if strings.Join(actual, ";") != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, strings.Join(actual, ";"))
+			}
+		})
+	}
+}
+
+func TestJSON6902PatchComments(t *testing.T) {
+	const input = `apiVersion: v1
+kind: Pod
+metadata:
+  name: a
+spec:
+  args:
+  - one # first
+  - two # second
+  - three # third
+  containers:
+  - name: a # container a
+    image: a
+  - name: b # container b
+    image: b
+`
+
+	var tests = []struct {
+		name     string
+		patch    string
+		expected string
+	}{
+		{
+			name:  "insert scalar",
+			patch: "- op: add\n  path: /spec/args/1\n  value: inserted\n",
+			expected: `  args:
+  - one # first
+  - inserted
+  - two # second
+  - three # third
+`,
+		},
+		{
+			name:  "remove scalar",
+			patch: "- op: remove\n  path: /spec/args/1\n",
+			expected: `  args:
+  - one # first
+  - three # third
+`,
+		},
+		{
+			name:  "insert mapping",
+			patch: "- op: add\n  path: /spec/containers/1\n  value: {name: c, image: c}\n",
+			expected: `  containers:
+  - name: a # container a
+    image: a
+  - image: c
+    name: c
+  - name: b # container b
+    image: b
+`,
+		},
+		{
+			name:  "remove mapping",
+			patch: "- op: remove\n  path: /spec/containers/0\n",
+			expected: `  containers:
+  - name: b # container b
+    image: b
+`,
+		},
+		{
+			name:  "replace mapping field",
+			patch: "- op: replace\n  path: /spec/containers/1/image\n  value: patched\n",
+			expected: `  containers:
+  - name: a # container a
+    image: a
+  - name: b # container b
+    image: patched
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch, err := json6902Patch(yaml.MustParse(test.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := patch(yaml.MustParse(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := result.String()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(actual, test.expected) {
+				t.Errorf("expected output to contain:\n%s\ngot:\n%s", test.expected, actual)
+			}
+		})
+	}
+}
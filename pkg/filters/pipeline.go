@@ -0,0 +1,74 @@
+package filters
+
+import (
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// PipelineFunctionName identifies a filter that can be run in the pipeline of a package.
+type PipelineFunctionName string
+
+const (
+	// PipelineFunctionHashDependency runs the HashDependencyFilter on the resources of a package.
+	PipelineFunctionHashDependency PipelineFunctionName = "hashDependency"
+	// PipelineFunctionTemplate runs the TemplateFilter on the resources of a package.
+	PipelineFunctionTemplate PipelineFunctionName = "template"
+)
+
+// PipelineFunction defines a filter that is run in-process on the resources of a single package after its setters,
+// templates and patches have been applied.
+type PipelineFunction struct {
+	// Name specifies the filter that is run.
+	Name PipelineFunctionName `yaml:"name,omitempty"`
+}
+
+// isValid returns whether the name identifies a known filter.
+func (n PipelineFunctionName) isValid() bool {
+	switch n {
+	case PipelineFunctionHashDependency, PipelineFunctionTemplate:
+		return true
+	default:
+		return false
+	}
+}
+
+// packagePipeline returns the pipeline of the specified package of the specified ClusterPackages resource. The
+// pipeline of the package takes precedence over the pipeline of the ClusterPackages resource, even if it is empty.
+func packagePipeline(res *ClusterPackages, pkg *Package) []PipelineFunction {
+	if pkg.Pipeline != nil {
+		return pkg.Pipeline
+	}
+
+	return res.Spec.Pipeline
+}
+
+// pipelineFilter returns a kio.Filter that runs the pipeline of the specified package of the specified
+// ClusterPackages resource in order.
+func (f *ClusterPackagesFilter) pipelineFilter(res *ClusterPackages, pkg *Package) kio.Filter {
+	pipeline := packagePipeline(res, pkg)
+
+	return kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+		for _, fn := range pipeline {
+			var filter kio.Filter
+			switch fn.Name {
+			case PipelineFunctionHashDependency:
+				filter = &HashDependencyFilter{Logger: f.Logger}
+			case PipelineFunctionTemplate:
+				filter = &TemplateFilter{}
+			default:
+				return nil, errors.Errorf("pipeline function %s is invalid", fn.Name)
+			}
+
+			f.Logger.Debug().Msgf("Running pipeline function %s on package %s", fn.Name, pkg.Name)
+
+			var err error
+			nodes, err = filter.Filter(nodes)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "pipeline function %s failed on package %s", fn.Name, pkg.Name)
+			}
+		}
+
+		return nodes, nil
+	})
+}
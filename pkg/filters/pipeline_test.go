@@ -0,0 +1,91 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+func TestPipelineFilter(t *testing.T) {
+	// The template cannot be parsed, so the template function fails if it is run.
+	const input = `apiVersion: kpt.dev/v1alpha1
+kind: Kptfile
+metadata:
+  name: pkg
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+data:
+  # {"$kpt-template":"true"}
+  key: '{{value "region"'
+`
+
+	template := []PipelineFunction{{Name: PipelineFunctionTemplate}}
+
+	var tests = []struct {
+		name            string
+		specPipeline    []PipelineFunction
+		packagePipeline []PipelineFunction
+		err             string
+	}{
+		{
+			name: "no pipeline",
+		},
+		{
+			name:         "spec pipeline",
+			specPipeline: template,
+			err:          "pipeline function template failed on package sample",
+		},
+		{
+			name:            "package pipeline",
+			packagePipeline: template,
+			err:             "pipeline function template failed on package sample",
+		},
+		{
+			name:            "empty package pipeline overrides spec pipeline",
+			specPipeline:    template,
+			packagePipeline: []PipelineFunction{},
+		},
+		{
+			name:            "package pipeline overrides spec pipeline",
+			specPipeline:    template,
+			packagePipeline: []PipelineFunction{{Name: PipelineFunctionHashDependency}},
+		},
+		{
+			name:            "invalid function",
+			packagePipeline: []PipelineFunction{{Name: "unknown"}},
+			err:             "pipeline function unknown is invalid",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodes, err := (&kio.ByteReader{Reader: strings.NewReader(input), OmitReaderAnnotations: true}).Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f := &ClusterPackagesFilter{Logger: zerolog.Nop()}
+			res := &ClusterPackages{Spec: ClusterPackagesSpec{Pipeline: test.specPipeline}}
+			pkg := &Package{Name: "sample", Pipeline: test.packagePipeline}
+			output, err := f.pipelineFilter(res, pkg).Filter(nodes)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(output) != len(nodes) {
+				t.Errorf("expected %d nodes, got %d", len(nodes), len(output))
+			}
+		})
+	}
+}
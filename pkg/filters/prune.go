@@ -0,0 +1,216 @@
+package filters
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// ClusterPackagesInventoryKind defines the kind used by the ClusterPackagesInventory resource.
+	ClusterPackagesInventoryKind = "ClusterPackagesInventory"
+
+	// OwnedByAnnotation defines the annotation that records the name of the ClusterPackages resource that
+	// rendered a resource. It is only added when pruning is enabled.
+	OwnedByAnnotation = "kpt.seek.com/owned-by"
+
+	// inventoryFileSuffix defines the suffix that replaces the extension of a ClusterPackages file to form the
+	// name of its inventory file, e.g. packages.yaml is inventoried by packages.inventory.yaml.
+	inventoryFileSuffix = ".inventory.yaml"
+	// defaultInventoryFileName defines the name of the inventory file used when a ClusterPackages resource has
+	// no path.
+	defaultInventoryFileName = "packages" + inventoryFileSuffix
+)
+
+// ClusterPackagesInventory records the paths of all of the files that were rendered for a ClusterPackages
+// resource, so that files that are no longer rendered can be pruned.
+type ClusterPackagesInventory struct {
+	// Standard Kubernetes metadata. The name matches the name of the inventoried ClusterPackages resource.
+	yaml.ResourceMeta `json:",inline" yaml:",inline"`
+	// Spec provides the resource specification.
+	Spec ClusterPackagesInventorySpec `yaml:"spec,omitempty"`
+}
+
+// ClusterPackagesInventorySpec defines the main body of the ClusterPackagesInventory resource.
+type ClusterPackagesInventorySpec struct {
+	// Paths specifies the sorted paths of the rendered files.
+	Paths []string `yaml:"paths,omitempty"`
+}
+
+// contains returns whether the inventory records the specified path.
+func (i *ClusterPackagesInventory) contains(p string) bool {
+	for _, inventoried := range i.Spec.Paths {
+		if path.Clean(inventoried) == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isClusterPackagesInventory returns whether the specified resource metadata pertains to a
+// ClusterPackagesInventory resource.
+func isClusterPackagesInventory(meta yaml.ResourceMeta) bool {
+	return meta.APIVersion == ClusterPackagesAPIVersion && meta.Kind == ClusterPackagesInventoryKind
+}
+
+// inventoryPath returns the path of the inventory file for a ClusterPackages resource with the specified path.
+func inventoryPath(clusterPackagesPath string) string {
+	if clusterPackagesPath == "" {
+		return defaultInventoryFileName
+	}
+
+	return strings.TrimSuffix(clusterPackagesPath, path.Ext(clusterPackagesPath)) + inventoryFileSuffix
+}
+
+// inventoryKey returns the key that identifies the inventory at the specified path of the ClusterPackages resource
+// with the specified name, in the same way as lockKey.
+func inventoryKey(inventoryFilePath, name string) string {
+	if inventoryFilePath == "" {
+		inventoryFilePath = defaultInventoryFileName
+	}
+
+	return path.Clean(inventoryFilePath) + ":" + name
+}
+
+// clusterPackagesInventoryKey returns the key that identifies the inventory of the specified ClusterPackages
+// resource.
+func clusterPackagesInventoryKey(res *ClusterPackages) string {
+	return inventoryKey(inventoryPath(res.Annotations[kioutil.PathAnnotation]), res.Name)
+}
+
+// ownedByFilter returns a kio.Filter that marks resources as owned by the specified ClusterPackages resource.
+func ownedByFilter(res *ClusterPackages) kio.Filter {
+	return kio.FilterAll(yaml.SetAnnotation(OwnedByAnnotation, res.Name))
+}
+
+// newInventoryNode returns the resource node for the inventory of the specified ClusterPackages resource.
+func newInventoryNode(res *ClusterPackages, nodes []*yaml.RNode) (*yaml.RNode, error) {
+	paths := map[string]bool{}
+	for _, node := range nodes {
+		meta, err := node.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		if p := meta.Annotations[kioutil.PathAnnotation]; p != "" {
+			paths[path.Clean(p)] = true
+		}
+	}
+
+	inventory := ClusterPackagesInventory{
+		ResourceMeta: yaml.ResourceMeta{
+			TypeMeta: yaml.TypeMeta{APIVersion: ClusterPackagesAPIVersion, Kind: ClusterPackagesInventoryKind},
+			ObjectMeta: yaml.ObjectMeta{
+				NameMeta: yaml.NameMeta{Name: res.Name},
+				Annotations: map[string]string{
+					kioutil.PathAnnotation: inventoryPath(res.Annotations[kioutil.PathAnnotation]),
+				},
+			},
+		},
+	}
+	for p := range paths {
+		inventory.Spec.Paths = append(inventory.Spec.Paths, p)
+	}
+	sort.Strings(inventory.Spec.Paths)
+
+	b, err := yaml.Marshal(inventory)
+	if err != nil {
+		return nil, errors.WrapPrefixf(err, "could not marshal inventory for %s", res.Name)
+	}
+
+	return yaml.Parse(string(b))
+}
+
+// pruneProblems returns a description of every file glob of the specified ClusterPackages resources, which cannot be
+// used when pruning. Pruning requires the function to be run in-place, which leaves no opportunity to write the
+// PackageFile resources as files, so they would overwrite the files that they wrap and would never be pruned.
+func pruneProblems(resources []*ClusterPackages) []string {
+	var problems []string
+	for _, res := range resources {
+		if res == nil {
+			continue
+		}
+
+		if len(res.Spec.Files) > 0 {
+			problems = append(problems, fmt.Sprintf("ClusterPackages %s files cannot be used with prune", res.Name))
+		}
+		for _, pkg := range res.Spec.Packages {
+			if len(pkg.Files) > 0 {
+				problems = append(problems, fmt.Sprintf("ClusterPackages %s package %q files cannot be used with prune",
+					res.Name, pkg.Name))
+			}
+		}
+	}
+
+	return problems
+}
+
+// isUnderDir returns whether the specified path is inside the specified directory.
+func isUnderDir(p, dir string) bool {
+	p, dir = path.Clean(p), path.Clean(dir)
+	return dir == "." || strings.HasPrefix(p, dir+"/")
+}
+
+// pruneCandidates returns the input indexes of the previously rendered resources that are replaced by the
+// resources rendered for the specified ClusterPackages resources. Every input resource under the baseDir of a
+// ClusterPackages resource must have been rendered by it, as recorded by both its inventory and the
+// OwnedByAnnotation; otherwise an error listing every other resource is returned and nothing is pruned. Inventories
+// are keyed by inventoryKey.
+func pruneCandidates(input []*yaml.RNode, resources []*ClusterPackages,
+	inventories map[string]*ClusterPackagesInventory) (map[int]bool, error) {
+	pruned := map[int]bool{}
+	var problems []string
+
+	for i, node := range input {
+		if resources[i] != nil {
+			continue
+		}
+
+		meta, err := node.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		if isClusterPackagesLock(meta) || isClusterPackagesInventory(meta) {
+			continue
+		}
+
+		p := meta.Annotations[kioutil.PathAnnotation]
+		if p == "" {
+			continue
+		}
+		p = path.Clean(p)
+
+		for _, res := range resources {
+			if res == nil || !isUnderDir(p, res.Spec.BaseDir) {
+				continue
+			}
+
+			inventory, ok := inventories[clusterPackagesInventoryKey(res)]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("%s is in baseDir %s of ClusterPackages %s, which has no inventory",
+					p, res.Spec.BaseDir, res.Name))
+			case !inventory.contains(p):
+				problems = append(problems, fmt.Sprintf("%s is in baseDir %s of ClusterPackages %s but is not in its inventory",
+					p, res.Spec.BaseDir, res.Name))
+			case meta.Annotations[OwnedByAnnotation] != res.Name:
+				problems = append(problems, fmt.Sprintf("%s %s in %s is in baseDir %s of ClusterPackages %s but is not owned by it",
+					meta.Kind, meta.Name, p, res.Spec.BaseDir, res.Name))
+			default:
+				pruned[i] = true
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problemsError("refusing to prune resources that were not rendered by sync", problems)
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,247 @@
+package filters
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
+
+const (
+	// minAbbreviatedHashLength defines the minimum number of hex characters that are considered an
+	// abbreviated commit SHA. This matches the minimum accepted by Git itself.
+	minAbbreviatedHashLength = 4
+
+	// maxListedCandidates limits the number of candidate refs listed in resolution errors.
+	maxListedCandidates = 20
+)
+
+// refCandidates returns the reference names that a ref is looked up as. Branches are looked up as remote-tracking
+// branches first, since local branches in a cached clone are not updated when the repository is fetched.
+func refCandidates(ref string) [][]plumbing.ReferenceName {
+	if strings.HasPrefix(ref, "refs/") {
+		return [][]plumbing.ReferenceName{{plumbing.ReferenceName(ref)}}
+	}
+
+	return [][]plumbing.ReferenceName{
+		{plumbing.NewTagReferenceName(ref)},
+		{plumbing.NewRemoteReferenceName(git.DefaultRemoteName, ref), plumbing.NewBranchReferenceName(ref)},
+	}
+}
+
+// resolveRef resolves the specified ref to a commit hash in the specified repository. The ref may be a branch
+// name, a lightweight or annotated tag, a full reference name or a full or abbreviated commit SHA. An error
+// listing the candidate refs is returned when the ref is ambiguous or cannot be found.
+func resolveRef(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	if ref == "" {
+		return plumbing.ZeroHash, errors.Errorf("no ref specified")
+	}
+
+	// Named references take precedence over abbreviated commit SHAs, as they do in Git.
+	matches := map[plumbing.Hash][]string{}
+	for _, names := range refCandidates(ref) {
+		for _, name := range names {
+			r, err := storer.ResolveReference(repo.Storer, name)
+			if err != nil {
+				if err == plumbing.ErrReferenceNotFound {
+					continue
+				}
+				return plumbing.ZeroHash, errors.WrapPrefixf(err, "error resolving reference %s", name)
+			}
+
+			commit, err := peelToCommit(repo, r.Hash())
+			if err != nil {
+				return plumbing.ZeroHash, errors.WrapPrefixf(err, "error resolving reference %s", name)
+			}
+
+			matches[commit.Hash] = append(matches[commit.Hash], name.String())
+			break
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+	case 1:
+		for h := range matches {
+			return h, nil
+		}
+	default:
+		var candidates []string
+		for h, names := range matches {
+			for _, n := range names {
+				candidates = append(candidates, fmt.Sprintf("%s (%s)", n, h))
+			}
+		}
+		return plumbing.ZeroHash, ambiguousRefError(ref, candidates)
+	}
+
+	if !isHashPrefix(ref) {
+		return plumbing.ZeroHash, notFoundRefError(repo, ref)
+	}
+
+	if len(ref) == 40 {
+		commit, err := repo.CommitObject(plumbing.NewHash(ref))
+		if err != nil {
+			if err == plumbing.ErrObjectNotFound {
+				return plumbing.ZeroHash, notFoundRefError(repo, ref)
+			}
+			return plumbing.ZeroHash, errors.WrapPrefixf(err, "error reading commit %s", ref)
+		}
+		return commit.Hash, nil
+	}
+
+	hashes, err := commitsWithPrefix(repo, ref)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	switch len(hashes) {
+	case 0:
+		return plumbing.ZeroHash, notFoundRefError(repo, ref)
+	case 1:
+		return hashes[0], nil
+	default:
+		var candidates []string
+		for _, h := range hashes {
+			candidates = append(candidates, h.String())
+		}
+		return plumbing.ZeroHash, ambiguousRefError(ref, candidates)
+	}
+}
+
+// peelToCommit returns the commit that the specified object hash refers to, dereferencing annotated tags.
+func peelToCommit(repo *git.Repository, h plumbing.Hash) (*object.Commit, error) {
+	obj, err := repo.Object(plumbing.AnyObject, h)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o := obj.(type) {
+	case *object.Commit:
+		return o, nil
+	case *object.Tag:
+		return o.Commit()
+	default:
+		return nil, errors.Errorf("object %s is a %s rather than a commit", h, obj.Type())
+	}
+}
+
+// isHashPrefix returns whether the specified ref could be a full or abbreviated commit SHA.
+func isHashPrefix(ref string) bool {
+	if len(ref) < minAbbreviatedHashLength || len(ref) > 40 {
+		return false
+	}
+
+	_, err := hex.DecodeString(ref + strings.Repeat("0", len(ref)%2))
+	return err == nil
+}
+
+// commitsWithPrefix returns the hashes of all commits in the repository whose SHA starts with the specified prefix.
+func commitsWithPrefix(repo *git.Repository, prefix string) ([]plumbing.Hash, error) {
+	prefix = strings.ToLower(prefix)
+
+	iter, err := repo.CommitObjects()
+	if err != nil {
+		return nil, errors.WrapPrefixf(err, "error listing commits")
+	}
+	defer iter.Close()
+
+	var hashes []plumbing.Hash
+	if err := iter.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), prefix) {
+			hashes = append(hashes, c.Hash)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.WrapPrefixf(err, "error listing commits")
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i].String() < hashes[j].String()
+	})
+
+	return hashes, nil
+}
+
+// refNotFoundError is returned by resolveRef when a ref does not match any commit in a repository.
+type refNotFoundError struct {
+	ref        string
+	candidates []string
+}
+
+// Error implements error.
+func (e *refNotFoundError) Error() string {
+	if len(e.candidates) == 0 {
+		return fmt.Sprintf("ref %s not found, repository has no branches or tags", e.ref)
+	}
+
+	candidates := e.candidates
+	suffix := ""
+	if len(candidates) > maxListedCandidates {
+		suffix = fmt.Sprintf(" (and %d more)", len(candidates)-maxListedCandidates)
+		candidates = candidates[:maxListedCandidates]
+	}
+
+	return fmt.Sprintf("ref %s not found, available branches and tags are: %s%s",
+		e.ref, strings.Join(candidates, ", "), suffix)
+}
+
+// isRefNotFound returns whether the specified error indicates that a ref could not be found.
+func isRefNotFound(err error) bool {
+	_, ok := err.(*refNotFoundError)
+	return ok
+}
+
+// ambiguousRefError returns an error describing a ref that matches more than one commit.
+func ambiguousRefError(ref string, candidates []string) error {
+	sort.Strings(candidates)
+	return errors.Errorf("ref %s is ambiguous, candidates are: %s", ref, strings.Join(candidates, ", "))
+}
+
+// notFoundRefError returns a refNotFoundError for the specified ref, listing the branches and tags that are
+// available in the repository.
+func notFoundRefError(repo *git.Repository, ref string) error {
+	var candidates []string
+
+	refs, err := repo.References()
+	if err == nil {
+		_ = refs.ForEach(func(r *plumbing.Reference) error {
+			name := r.Name()
+			switch {
+			case name.IsTag():
+				candidates = append(candidates, name.Short())
+			case name.IsRemote() && !strings.HasSuffix(name.String(), "/"+plumbing.HEAD.String()):
+				candidates = append(candidates, strings.TrimPrefix(name.Short(), git.DefaultRemoteName+"/"))
+			case name.IsBranch():
+				candidates = append(candidates, name.Short())
+			}
+			return nil
+		})
+	}
+
+	candidates = uniqueStrings(candidates)
+	sort.Strings(candidates)
+
+	return &refNotFoundError{ref: ref, candidates: candidates}
+}
+
+// uniqueStrings returns the specified strings with duplicates removed, preserving order.
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var output []string
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		output = append(output, v)
+	}
+
+	return output
+}
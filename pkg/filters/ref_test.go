@@ -0,0 +1,129 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newTestRepository creates a Git repository in a temporary directory with the specified number of commits.
+func newTestRepository(t *testing.T, commits int) (string, *git.Repository, []plumbing.Hash) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, repo, addTestCommits(t, repo, commits)
+}
+
+// addTestCommits adds the specified number of commits to the current branch of a test repository.
+func addTestCommits(t *testing.T, repo *git.Repository, commits int) []plumbing.Hash {
+	var hashes []plumbing.Hash
+	for i := 0; i < commits; i++ {
+		hashes = append(hashes, commitTestFiles(t, repo, map[string]string{
+			"file.txt": time.Now().String() + strings.Repeat("x", i),
+		}))
+	}
+
+	return hashes
+}
+
+// commitTestFiles writes the specified files to the worktree of a test repository and commits them.
+func commitTestFiles(t *testing.T, repo *git.Repository, files map[string]string) plumbing.Hash {
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, contents := range files {
+		f, err := w.Filesystem.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := w.Commit("commit", &git.CommitOptions{Author: testSignature()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func testSignature() *object.Signature {
+	return &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)}
+}
+
+func TestResolveRef(t *testing.T) {
+	_, repo, hashes := newTestRepository(t, 3)
+
+	if _, err := repo.CreateTag("v1.0.0", hashes[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateTag("some-app/v1.4.0", hashes[1], &git.CreateTagOptions{
+		Tagger:  testSignature(),
+		Message: "release",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", hashes[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/remotes/origin/release", hashes[2])); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/v1.0.0", hashes[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		ref      string
+		expected plumbing.Hash
+		err      string
+	}{
+		{name: "full-sha", ref: hashes[1].String(), expected: hashes[1]},
+		{name: "short-sha", ref: hashes[2].String()[:10], expected: hashes[2]},
+		{name: "local-branch", ref: "feature", expected: hashes[1]},
+		{name: "remote-branch", ref: "release", expected: hashes[2]},
+		{name: "annotated-tag", ref: "some-app/v1.4.0", expected: hashes[1]},
+		{name: "full-reference-name", ref: "refs/tags/v1.0.0", expected: hashes[0]},
+		{name: "ambiguous-tag-and-branch", ref: "v1.0.0", err: "ref v1.0.0 is ambiguous"},
+		{name: "not-found", ref: "missing", err: "available branches and tags are: feature, master, release, some-app/v1.4.0, v1.0.0"},
+		{name: "unknown-full-sha", ref: strings.Repeat("a", 40), err: "not found"},
+		{name: "empty", ref: "", err: "no ref specified"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, err := resolveRef(repo, test.ref)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, h)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package filters
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	goerrors "github.com/go-errors/errors"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// defaultRetryBackoff defines the delay before the first retry when no RetryBackoff is configured.
+const defaultRetryBackoff = time.Second
+
+// timeoutError indicates that a single attempt of a Git operation exceeded the RepositoryTimeout.
+type timeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+// Error implements error.Error.
+func (e *timeoutError) Error() string {
+	return "timed out after " + e.timeout.String() + ": " + e.err.Error()
+}
+
+// Unwrap returns the error returned by the timed out operation.
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
+// retry invokes fn until it succeeds, returns an error that is not transient, or the configured number of Retries is
+// exhausted. Each attempt is bounded by the RepositoryTimeout, and the delay between attempts starts at the
+// RetryBackoff and doubles after each attempt.
+func (f *ClusterPackagesFilter) retry(ctx context.Context, description string, fn func(ctx context.Context) error) error {
+	backoff := f.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := f.attempt(ctx, fn)
+		if err == nil || attempt > f.Retries || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+
+		f.Logger.Debug().Err(err).Msgf("Attempt %d of %d to %s failed, retrying in %s",
+			attempt, f.Retries+1, description, backoff)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt invokes fn with a context that is cancelled after the RepositoryTimeout, if one is configured.
+func (f *ClusterPackagesFilter) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if f.RepositoryTimeout <= 0 {
+		return fn(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, f.RepositoryTimeout)
+	defer cancel()
+
+	err := fn(attemptCtx)
+	if err != nil && ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded {
+		return &timeoutError{timeout: f.RepositoryTimeout, err: err}
+	}
+
+	return err
+}
+
+// isTransient returns whether the specified error may be resolved by retrying the operation that returned it, such
+// as network failures, timeouts and server errors. Errors such as authentication failures, unknown hosts, invalid
+// certificates and missing repositories or refs are not transient.
+func isTransient(err error) bool {
+	chain := errorChain(err)
+
+	// Errors that are never transient are looked for throughout the chain first, as they are usually wrapped by
+	// errors such as *url.Error and *net.OpError that are otherwise treated as transient.
+	for _, err := range chain {
+		switch e := err.(type) {
+		case *net.DNSError:
+			return !e.IsNotFound
+		case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError, tls.RecordHeaderError:
+			return false
+		}
+	}
+
+	for _, err := range chain {
+		switch e := err.(type) {
+		case *timeoutError:
+			return true
+		case *githttp.Err:
+			return e.StatusCode() >= http.StatusInternalServerError || e.StatusCode() == http.StatusTooManyRequests
+		case net.Error:
+			if e.Timeout() {
+				return true
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ETIMEDOUT) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errorChain returns the specified error followed by each of the errors that it wraps.
+func errorChain(err error) []error {
+	var chain []error
+	for err != nil {
+		chain = append(chain, err)
+
+		// Errors created by sigs.k8s.io/kustomize/kyaml/errors do not support errors.Unwrap.
+		if e, ok := err.(*goerrors.Error); ok {
+			err = e.Err
+		} else {
+			err = errors.Unwrap(err)
+		}
+	}
+
+	return chain
+}
+
+// dialTimeoutAuth wraps an SSH auth method to bound the time taken to connect to the SSH server.
+type dialTimeoutAuth struct {
+	ssh.AuthMethod
+	timeout time.Duration
+}
+
+// ClientConfig implements ssh.AuthMethod.ClientConfig.
+func (a *dialTimeoutAuth) ClientConfig() (*cryptossh.ClientConfig, error) {
+	config, err := a.AuthMethod.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	config.Timeout = a.timeout
+	return config, nil
+}
+
+// withDialTimeout returns the specified SSH auth method, bounding the time taken to connect to the SSH server by
+// the RepositoryTimeout if one is configured.
+func (f *ClusterPackagesFilter) withDialTimeout(auth ssh.AuthMethod) ssh.AuthMethod {
+	if f.RepositoryTimeout <= 0 {
+		return auth
+	}
+
+	return &dialTimeoutAuth{AuthMethod: auth, timeout: f.RepositoryTimeout}
+}
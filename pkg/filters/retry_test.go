@@ -0,0 +1,157 @@
+package filters
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
+
+func TestIsTransient(t *testing.T) {
+	var tests = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "timeout", err: &timeoutError{timeout: time.Second, err: context.DeadlineExceeded}, expected: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, expected: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, expected: true},
+		{name: "temporary DNS failure", err: &net.DNSError{Err: "server misbehaving", Name: "github.com"}, expected: true},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "github.com", IsNotFound: true}},
+		{name: "wrapped", err: errors.WrapPrefixf(io.EOF, "error cloning Git repository"), expected: true},
+		{
+			name:     "wrapped connection refused",
+			err:      &url.Error{Op: "Get", URL: "https://github.com", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}},
+			expected: true,
+		},
+		{
+			name: "wrapped unknown host",
+			err:  &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "github.com", IsNotFound: true}},
+		},
+		{
+			name: "url wrapped unknown host",
+			err: &url.Error{Op: "Get", URL: "https://github.com", Err: &net.OpError{
+				Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "github.com", IsNotFound: true},
+			}},
+		},
+		{
+			name: "kyaml wrapped unknown host",
+			err: errors.WrapPrefixf(&url.Error{Op: "Get", URL: "https://github.com", Err: &net.DNSError{
+				Err: "no such host", Name: "github.com", IsNotFound: true,
+			}}, "error cloning Git repository"),
+		},
+		{
+			name:     "wrapped temporary DNS failure",
+			err:      &net.OpError{Op: "dial", Err: &net.DNSError{Err: "server misbehaving", Name: "github.com"}},
+			expected: true,
+		},
+		{
+			name: "untrusted certificate",
+			err:  &url.Error{Op: "Get", URL: "https://github.com", Err: x509.UnknownAuthorityError{}},
+		},
+		{
+			name: "certificate hostname mismatch",
+			err:  &url.Error{Op: "Get", URL: "https://github.com", Err: x509.HostnameError{Host: "github.com"}},
+		},
+		{name: "url error", err: &url.Error{Op: "Get", URL: "https://github.com", Err: errors.Errorf("malformed")}},
+		{name: "authentication required", err: transport.ErrAuthenticationRequired},
+		{name: "repository not found", err: errors.WrapPrefixf(transport.ErrRepositoryNotFound, "error cloning")},
+		{name: "ref not found", err: errors.Errorf("could not resolve ref v1.0.0")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := isTransient(test.err); actual != test.expected {
+				t.Errorf("expected %t, got %t for %v", test.expected, actual, test.err)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var tests = []struct {
+		name             string
+		err              error
+		succeedOn        int
+		expectedAttempts int
+		expectError      bool
+	}{
+		{name: "success", succeedOn: 1, expectedAttempts: 1},
+		{name: "transient then success", err: io.EOF, succeedOn: 2, expectedAttempts: 2},
+		{name: "transient exhausted", err: io.EOF, expectedAttempts: 3, expectError: true},
+		{name: "not transient", err: transport.ErrAuthenticationRequired, expectedAttempts: 1, expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &ClusterPackagesFilter{
+				Retries:      2,
+				RetryBackoff: time.Millisecond,
+				Logger:       zerolog.Nop(),
+			}
+
+			attempts := 0
+			err := f.retry(context.Background(), "test", func(ctx context.Context) error {
+				attempts++
+				if attempts == test.succeedOn {
+					return nil
+				}
+				return test.err
+			})
+
+			if test.expectError != (err != nil) {
+				t.Errorf("expected error %t, got %v", test.expectError, err)
+			}
+			if attempts != test.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", test.expectedAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryRepositoryTimeout(t *testing.T) {
+	f := &ClusterPackagesFilter{
+		RepositoryTimeout: 10 * time.Millisecond,
+		Retries:           1,
+		RetryBackoff:      time.Millisecond,
+		Logger:            zerolog.Nop(),
+	}
+
+	attempts := 0
+	err := f.retry(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if _, ok := err.(*timeoutError); !ok {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	block := make(chan struct{})
+	defer close(block)
+
+	err := waitContext(ctx, func() error {
+		<-block
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
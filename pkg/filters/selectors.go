@@ -0,0 +1,107 @@
+package filters
+
+import (
+	"path"
+
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// PackageSelector selects files and resources of a package.
+type PackageSelector struct {
+	// Files specifies globs that match the paths of files relative to the package. Globs without a slash match file
+	// names, and globs with a slash match paths relative to the package.
+	Files []string `yaml:"files,omitempty"`
+	// Resources specifies selectors that match resources. A resource is selected if it matches any of them.
+	Resources []ResourceSelector `yaml:"resources,omitempty"`
+}
+
+// ResourceSelector selects resources by kind, name, namespace and labels. A resource matches if it matches every
+// field that is specified.
+type ResourceSelector struct {
+	// Kind specifies the kind of the resource.
+	Kind string `yaml:"kind,omitempty"`
+	// Name specifies the name of the resource.
+	Name string `yaml:"name,omitempty"`
+	// Namespace specifies the namespace of the resource.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Labels specifies labels that the resource must have.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// matches returns whether the specified resource metadata matches the selector.
+func (s ResourceSelector) matches(meta yaml.ResourceMeta) bool {
+	if s.Kind != "" && s.Kind != meta.Kind {
+		return false
+	}
+	if s.Name != "" && s.Name != meta.Name {
+		return false
+	}
+	if s.Namespace != "" && s.Namespace != meta.Namespace {
+		return false
+	}
+	for k, v := range s.Labels {
+		if actual, ok := meta.Labels[k]; !ok || actual != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesFile returns whether the file at the specified path relative to its package matches any of the Files globs.
+func (s PackageSelector) matchesFile(p string) bool {
+	return matchesAny(s.Files, path.Base(p), p)
+}
+
+// matchesResource returns whether the specified resource metadata matches any of the Resources selectors.
+func (s PackageSelector) matchesResource(meta yaml.ResourceMeta) bool {
+	for _, selector := range s.Resources {
+		if selector.matches(meta) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectPackageNodes returns the nodes of the specified package that are selected by its Include and Exclude
+// selectors. A node is selected if its file matches the Include file globs, if any, and it matches the Include
+// resource selectors, if any, and it matches neither the Exclude file globs nor the Exclude resource selectors.
+// Kptfiles are always selected, and resource selectors do not apply to PackageFile resources.
+func (f *ClusterPackagesFilter) selectPackageNodes(pkg *Package, nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	var selected []*yaml.RNode
+	for _, node := range nodes {
+		if isKptfile(node) {
+			selected = append(selected, node)
+			continue
+		}
+
+		meta, err := node.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		p := path.Clean(meta.Annotations[kioutil.PathAnnotation])
+		isResource := !isPackageFile(meta)
+
+		var reason string
+		switch {
+		case len(pkg.Include.Files) > 0 && !pkg.Include.matchesFile(p):
+			reason = "its file is not included"
+		case isResource && len(pkg.Include.Resources) > 0 && !pkg.Include.matchesResource(meta):
+			reason = "it is not included"
+		case pkg.Exclude.matchesFile(p):
+			reason = "its file is excluded"
+		case isResource && pkg.Exclude.matchesResource(meta):
+			reason = "it is excluded"
+		default:
+			selected = append(selected, node)
+			continue
+		}
+
+		f.Logger.Debug().Msgf("Skipping %s %s in %s of package %s as %s", meta.Kind, meta.Name, p, pkg.Name, reason)
+	}
+
+	return selected, nil
+}
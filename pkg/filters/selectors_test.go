@@ -0,0 +1,135 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+func TestSelectPackageNodes(t *testing.T) {
+	const input = `apiVersion: kpt.dev/v1alpha1
+kind: Kptfile
+metadata:
+  name: pkg
+  annotations:
+    config.kubernetes.io/path: Kptfile
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  labels:
+    app: a
+  annotations:
+    config.kubernetes.io/path: a.yaml
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+  annotations:
+    config.kubernetes.io/path: sub/b.yaml
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: c
+  annotations:
+    config.kubernetes.io/path: c.yaml
+`
+
+	var tests = []struct {
+		name     string
+		include  PackageSelector
+		exclude  PackageSelector
+		expected string
+	}{
+		{
+			name:     "no selectors",
+			expected: "pkg,a,b,c",
+		},
+		{
+			name:     "include files",
+			include:  PackageSelector{Files: []string{"sub/*.yaml"}},
+			expected: "pkg,b",
+		},
+		{
+			name:     "include resources",
+			include:  PackageSelector{Resources: []ResourceSelector{{Kind: "Secret"}, {Labels: map[string]string{"app": "a"}}}},
+			expected: "pkg,a,c",
+		},
+		{
+			name:     "exclude files",
+			exclude:  PackageSelector{Files: []string{"c.yaml"}},
+			expected: "pkg,a,b",
+		},
+		{
+			name:     "include and exclude same resource",
+			include:  PackageSelector{Resources: []ResourceSelector{{Kind: "ConfigMap"}}},
+			exclude:  PackageSelector{Resources: []ResourceSelector{{Name: "a"}}},
+			expected: "pkg,b",
+		},
+		{
+			name:     "include and exclude same file",
+			include:  PackageSelector{Files: []string{"*.yaml"}},
+			exclude:  PackageSelector{Files: []string{"a.yaml"}},
+			expected: "pkg,b,c",
+		},
+		{
+			name:     "empty include selector",
+			include:  PackageSelector{Resources: []ResourceSelector{{}}},
+			expected: "pkg,a,b,c",
+		},
+		{
+			name:     "empty exclude selector",
+			exclude:  PackageSelector{Resources: []ResourceSelector{{}}},
+			expected: "pkg",
+		},
+		{
+			name:     "include matches nothing",
+			include:  PackageSelector{Resources: []ResourceSelector{{Kind: "Deployment"}}},
+			expected: "pkg",
+		},
+		{
+			name:     "include files match nothing",
+			include:  PackageSelector{Files: []string{"*.json"}},
+			expected: "pkg",
+		},
+		{
+			name:     "exclude matches nothing",
+			exclude:  PackageSelector{Files: []string{"*.json"}, Resources: []ResourceSelector{{Namespace: "other"}}},
+			expected: "pkg,a,b,c",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodes, err := (&kio.ByteReader{Reader: strings.NewReader(input), OmitReaderAnnotations: true}).Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f := &ClusterPackagesFilter{Logger: zerolog.Nop()}
+			pkg := &Package{Name: "sample", Include: test.include, Exclude: test.exclude}
+			selected, err := f.selectPackageNodes(pkg, nodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var actual []string
+			for _, node := range selected {
+				meta, err := node.GetMeta()
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual = append(actual, meta.Name)
+			}
+
+			if strings.Join(actual, ",") != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, strings.Join(actual, ","))
+			}
+		})
+	}
+}
@@ -15,7 +15,9 @@ import (
 
 // SetPackageFilter provides a kio.Filter implementation that executes a setter on Kpt packages.
 // On each invocation of the Filter function, SetPackageFilter expects to be given a single Kpt
-// package, where exactly one of the resource nodes pertains to the Kptfile.
+// package, which may contain subpackages. The setter is executed on the Kptfile of each subpackage
+// and on the resources that it owns. For kpt v1 Kptfiles, the setter is set in the configs of their
+// apply-setters functions, which are then applied to the resources.
 type SetPackageFilter struct {
 	// Name is the name of the setter.
 	Name string
@@ -29,31 +31,35 @@ type SetPackageFilter struct {
 
 // Filter implements kio.Filter.
 func (f *SetPackageFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
-	kptfileNodes, err := KptfileFilter().Filter(nodes)
+	packages, err := groupSubpackages(nodes)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(kptfileNodes) != 1 {
-		return nil, errors.Errorf("expected a single Kptfile in package but got %d", len(kptfileNodes))
-	}
+	var output []*yaml.RNode
+	for _, p := range packages {
+		if isKptfileV1(p.kptfile) {
+			if err := f.applySetters(p); err != nil {
+				return nil, err
+			}
+			output = append(append(output, p.kptfile), p.resources...)
+			continue
+		}
 
-	notKptfileNodes, err := NotKptfileFilter().Filter(nodes)
-	if err != nil {
-		return nil, err
-	}
+		kptfileNodes, err := f.kptfileSetterFilter().Filter([]*yaml.RNode{p.kptfile})
+		if err != nil {
+			return nil, err
+		}
 
-	kptfileNodes, err = f.kptfileSetterFilter().Filter(kptfileNodes)
-	if err != nil {
-		return nil, err
-	}
+		resourceNodes, err := f.resourceSetterFilter(kptfileNodes[0]).Filter(p.resources)
+		if err != nil {
+			return nil, err
+		}
 
-	notKptfileNodes, err = f.resourceSetterFilter(kptfileNodes[0]).Filter(notKptfileNodes)
-	if err != nil {
-		return nil, err
+		output = append(append(output, kptfileNodes...), resourceNodes...)
 	}
 
-	return append(kptfileNodes, notKptfileNodes...), nil
+	return output, nil
 }
 
 // kptfileSetterFilter returns a kio.Filter that invokes a setter on Kptfile resource nodes.
@@ -63,21 +69,112 @@ func (f *SetPackageFilter) kptfileSetterFilter() kio.Filter {
 			return node, nil
 		}
 
-		if len(f.ListValues) > 0 {
-			f.Value = f.ListValues[0]
-			f.ListValues = f.ListValues[1:]
+		value, listValues := f.Value, f.ListValues
+		if len(listValues) > 0 {
+			value = listValues[0]
+			listValues = listValues[1:]
 		}
 
 		return setters2.SetOpenAPI{
 			Name:       f.Name,
-			Value:      f.Value,
-			ListValues: f.ListValues,
+			Value:      value,
+			ListValues: listValues,
 			SetBy:      f.SetBy,
 			IsSet:      true,
 		}.Filter(node)
 	}))
 }
 
+// applySetters sets the setter in the apply-setters configs of the specified subpackage, which has a kpt v1 Kptfile,
+// and applies the setters to its resources. Subpackages whose configs do not declare the setter are left unchanged.
+func (f *SetPackageFilter) applySetters(p *subpackage) error {
+	configs, err := applySettersConfigs(p)
+	if err != nil {
+		return err
+	}
+
+	found, err := setApplySetter(configs, f.Name, f.Value, f.ListValues)
+	if err != nil || !found {
+		return err
+	}
+
+	values, err := applySettersValues(configs)
+	if err != nil {
+		return err
+	}
+
+	return applySetters(p.resources, values)
+}
+
+// CascadeSettersFilter provides a kio.Filter implementation that cascades setters from Kpt packages to their
+// subpackages. Each setter of a subpackage that has not been set takes the value of the setter with the same name in
+// the nearest parent package that defines it, if it has been set there. Only kpt v1alpha1 setters are cascaded, as kpt
+// v1 Kptfiles do not record whether their setters have been set. Parents are processed before their children,
+// so values cascade through every level of subpackages.
+type CascadeSettersFilter struct{}
+
+// Filter implements kio.Filter.
+func (f *CascadeSettersFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	packages, err := groupSubpackages(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packages {
+		if p.parent == nil {
+			continue
+		}
+
+		names, err := setterNames(p.kptfile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			setter, err := kptfileSetter(p.kptfile, name)
+			if err != nil {
+				return nil, err
+			}
+			if setter.IsSet {
+				continue
+			}
+
+			inherited, err := inheritedSetter(p.parent, name)
+			if err != nil {
+				return nil, err
+			}
+			if inherited == nil || !inherited.IsSet {
+				continue
+			}
+
+			set := &SetPackageFilter{
+				Name:       name,
+				Value:      inherited.Value,
+				ListValues: inherited.ListValues,
+				SetBy:      inherited.SetBy,
+			}
+			if _, err := set.Filter(append([]*yaml.RNode{p.kptfile}, p.resources...)); err != nil {
+				return nil, errors.WrapPrefixf(err, "could not cascade setter %s to subpackage %s", name, p.dir)
+			}
+		}
+	}
+
+	return nodes, nil
+}
+
+// inheritedSetter returns the definition of the setter with the specified name in the nearest of the specified
+// subpackage and its parents that defines it, or nil if none of them do.
+func inheritedSetter(p *subpackage, name string) (*setters2.SetterDefinition, error) {
+	for ; p != nil; p = p.parent {
+		setter, err := kptfileSetter(p.kptfile, name)
+		if err != nil || setter != nil {
+			return setter, err
+		}
+	}
+
+	return nil, nil
+}
+
 // resourceSetterFilter returns a kio.Filter that invokes a setter on regular (i.e., non-Kptfile) resource nodes.
 func (f *SetPackageFilter) resourceSetterFilter(kptfile *yaml.RNode) kio.Filter {
 	schema, err := openAPISchema(kptfile)
@@ -42,6 +42,16 @@ func TestSet(t *testing.T) {
       },
       testdataPath:  "set_testdata/list_values/single",
     },
+    {
+      name: "set-list-values-subpackages",
+      filter:       &SetPackageFilter{
+        Name:       "hosts",
+        Value:      "",
+        ListValues: []string{"test.com", "hello.com"},
+        SetBy:      SetByClusterOverride,
+      },
+      testdataPath:  "set_testdata/list_values/subpackages",
+    },
   }
 
   for _, test := range tests {
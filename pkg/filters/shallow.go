@@ -0,0 +1,190 @@
+package filters
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
+
+// shallowDepth defines the number of commits fetched by shallow clones.
+const shallowDepth = 1
+
+// shallowCommitRefName defines the reference that a full commit SHA is fetched into by a shallow clone.
+const shallowCommitRefName = "refs/kpt-sync/commit"
+
+// isShallowRef returns whether the specified ref can be fetched shallowly. Branches, tags and full commit SHAs can be,
+// but abbreviated commit SHAs cannot, as a remote can only be asked for a commit by its full SHA.
+func isShallowRef(ref string) bool {
+	return !isHashPrefix(ref) || len(ref) == 2*len(plumbing.ZeroHash)
+}
+
+// shallowRepositoryDir returns the directory that the shallow clone of the specified ref of the specified repository
+// is cached in. Each ref is cached separately because go-git cannot reliably fetch into a shallow repository.
+func (f *ClusterPackagesFilter) shallowRepositoryDir(repoURL, ref string) string {
+	checksum := sha256.Sum256([]byte(ref))
+	return f.repositoryDir(repoURL) + "-shallow-" + hex.EncodeToString(checksum[:8])
+}
+
+// resolveShallow resolves the specified branch, tag or full commit SHA using a cached shallow clone of only that
+// ref, cloning it if it is not cached, or if it should be refreshed according to the configured CachePolicy. The
+// directory of the shallow clone is also returned, along with a function that releases the lock on it in the same
+// way as resolvePackage.
+func (f *ClusterPackagesFilter) resolveShallow(
+	ctx context.Context, repoURL, ref string) (plumbing.Hash, string, func(), error) {
+	repoDir := f.shallowRepositoryDir(repoURL, ref)
+	if hash, unlock, ok := f.resolveShared(repoURL, repoDir, ref); ok {
+		f.Logger.Debug().Msgf("Using ref %s of %s in %s", ref, repoURL, repoDir)
+		return hash, repoDir, unlock, nil
+	}
+
+	unlock, err := f.lockRepository(repoDir, true)
+	if err != nil {
+		return plumbing.ZeroHash, "", nil, err
+	}
+
+	hash, err := f.resolveShallowLocked(ctx, repoURL, ref, repoDir)
+	if err != nil {
+		unlock()
+		return plumbing.ZeroHash, "", nil, err
+	}
+
+	return hash, repoDir, unlock, nil
+}
+
+// resolveShallowLocked resolves the specified ref using the shallow clone in the specified directory, cloning it
+// again if it cannot be used. The exclusive lock on the directory must be held.
+func (f *ClusterPackagesFilter) resolveShallowLocked(
+	ctx context.Context, repoURL, ref, repoDir string) (plumbing.Hash, error) {
+	if _, err := os.Stat(repoDir); err == nil && f.cachePolicy(repoURL) != CachePolicyAlways {
+		f.Logger.Debug().Msgf("Using ref %s of %s in %s", ref, repoURL, repoDir)
+
+		hash, err := resolveRepositoryDirRef(repoDir, ref)
+		if err == nil {
+			return hash, nil
+		}
+
+		f.Logger.Debug().Msgf("Could not use cached ref %s of %s, cloning it again: %v", ref, repoURL, err)
+	}
+
+	if f.isOfflineRepository(repoURL) {
+		return plumbing.ZeroHash, errOffline(repoURL)
+	}
+
+	if err := os.RemoveAll(repoDir); err != nil {
+		return plumbing.ZeroHash, errors.WrapPrefixf(err, "error removing directory %s", repoDir)
+	}
+
+	hash, err := f.cloneShallow(ctx, repoURL, ref, repoDir)
+	if err != nil {
+		if err := os.RemoveAll(repoDir); err != nil {
+			f.Logger.Warn().Err(err).Msgf("Could not delete directory %s", repoDir)
+		}
+		return plumbing.ZeroHash, err
+	}
+
+	return hash, nil
+}
+
+// cloneShallow clones only the most recent commit of the branches and tags named by the specified ref, or only the
+// commit with the specified full SHA, into the specified directory, and resolves the ref. An error is returned if the
+// ref does not name a branch or tag on the remote, or if the remote does not support shallow fetches or fetching
+// commits by their SHA.
+func (f *ClusterPackagesFilter) cloneShallow(ctx context.Context, repoURL, ref, repoDir string) (plumbing.Hash, error) {
+	var hash plumbing.Hash
+	err := f.withRemotes(ctx, repoURL, func(ctx context.Context, remoteURL string) error {
+		if err := os.RemoveAll(repoDir); err != nil {
+			return errors.WrapPrefixf(err, "error removing directory %s", repoDir)
+		}
+
+		repo, err := git.PlainInit(repoDir, false)
+		if err != nil {
+			return errors.WrapPrefixf(err, "error creating Git repository %s", repoDir)
+		}
+
+		auth, err := f.auth(remoteURL)
+		if err != nil {
+			return err
+		}
+
+		remote, err := repo.CreateRemoteAnonymous(&config.RemoteConfig{
+			Name: anonymousRemoteName,
+			URLs: []string{remoteURL},
+		})
+		if err != nil {
+			return errors.WrapPrefixf(err, "error creating remote for Git repository %s", describeRemote(repoURL, remoteURL))
+		}
+
+		specs := []config.RefSpec{config.RefSpec(strings.ToLower(ref) + ":" + shallowCommitRefName)}
+		if !isHashPrefix(ref) {
+			remoteRefs, err := remote.List(&git.ListOptions{Auth: auth})
+			if err != nil {
+				return errors.WrapPrefixf(err, "error listing refs of Git repository %s", describeRemote(repoURL, remoteURL))
+			}
+
+			specs = shallowRefSpecs(remoteRefs, ref)
+			if len(specs) == 0 {
+				return errors.Errorf("ref %s is not a branch or tag of Git repository %s", ref,
+					describeRemote(repoURL, remoteURL))
+			}
+		}
+
+		f.Logger.Debug().Msgf("Cloning ref %s of repository %s to %s shallowly", ref, describeRemote(repoURL, remoteURL),
+			repoDir)
+
+		err = remote.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: anonymousRemoteName,
+			RefSpecs:   specs,
+			Auth:       auth,
+			Depth:      shallowDepth,
+			Tags:       git.NoTags,
+			Force:      true,
+		})
+		if err != nil {
+			return errors.WrapPrefixf(err, "error fetching Git repository %s", describeRemote(repoURL, remoteURL))
+		}
+
+		hash, err = resolveRef(repo, ref)
+		return err
+	})
+
+	return hash, err
+}
+
+// shallowRefSpecs returns the refspecs that fetch the branches and tags named by the specified ref from a remote with
+// the specified references, mapped to the same local references as a full fetch. Both a branch and a tag are fetched
+// if the remote has both, so that ambiguous refs are still detected when they are resolved.
+func shallowRefSpecs(remoteRefs []*plumbing.Reference, ref string) []config.RefSpec {
+	names := map[plumbing.ReferenceName]bool{}
+	for _, r := range remoteRefs {
+		names[r.Name()] = true
+	}
+
+	var candidates []plumbing.ReferenceName
+	if strings.HasPrefix(ref, "refs/") {
+		candidates = []plumbing.ReferenceName{plumbing.ReferenceName(ref)}
+	} else {
+		candidates = []plumbing.ReferenceName{plumbing.NewTagReferenceName(ref), plumbing.NewBranchReferenceName(ref)}
+	}
+
+	var specs []config.RefSpec
+	for _, name := range candidates {
+		if !names[name] {
+			continue
+		}
+
+		local := name
+		if name.IsBranch() {
+			local = plumbing.NewRemoteReferenceName(git.DefaultRemoteName, name.Short())
+		}
+		specs = append(specs, config.RefSpec("+"+name.String()+":"+local.String()))
+	}
+
+	return specs
+}
@@ -0,0 +1,159 @@
+package filters
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/GoogleContainerTools/kpt/pkg/kptfile"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/rs/zerolog"
+)
+
+func TestResolveShallow(t *testing.T) {
+	// The in-process server used for local repositories does not support shallow fetches, so the Git binary's
+	// upload-pack is used instead.
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack is not installed")
+	}
+	client.InstallProtocol(fileProtocol, file.DefaultClient)
+	defer InstallLocalTransport()
+
+	upstreamDir, upstream, hashes := newTestRepository(t, 3)
+	if _, err := upstream.CreateTag("v1", hashes[0], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &ClusterPackagesFilter{
+		CacheDir: t.TempDir(),
+		Logger:   zerolog.Nop(),
+		Shallow:  true,
+	}
+
+	ctx := context.Background()
+	var tests = []struct {
+		ref      string
+		policy   CachePolicy
+		expected func(newHash plumbing.Hash) plumbing.Hash
+	}{
+		{ref: "master", expected: func(plumbing.Hash) plumbing.Hash { return hashes[2] }},
+		{ref: "v1", expected: func(plumbing.Hash) plumbing.Hash { return hashes[0] }},
+		{ref: "master", policy: CachePolicyIfMissing, expected: func(plumbing.Hash) plumbing.Hash { return hashes[2] }},
+		{ref: "master", policy: CachePolicyAlways, expected: func(newHash plumbing.Hash) plumbing.Hash { return newHash }},
+	}
+
+	// A new commit is added after the first shallow clones, so that cache refreshes can be observed.
+	var newHash plumbing.Hash
+	for i, test := range tests {
+		if i == 2 {
+			newHash = addTestCommits(t, upstream, 1)[0]
+		}
+		f.CachePolicy = test.policy
+
+		hash, repoDir, unlock, err := f.resolveShallow(ctx, upstreamDir, test.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlock()
+		if expected := test.expected(newHash); hash != expected {
+			t.Fatalf("%s: expected %s, got %s", test.ref, expected, hash)
+		}
+
+		repo, err := git.PlainOpen(repoDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shallows, err := repo.Storer.Shallow(); err != nil || len(shallows) == 0 {
+			t.Fatalf("%s: expected a shallow repository, got %v", test.ref, err)
+		}
+		if _, err := repo.CommitObject(hashes[1]); err != plumbing.ErrObjectNotFound {
+			t.Fatalf("%s: expected intermediate commit to be missing, got %v", test.ref, err)
+		}
+	}
+}
+
+func TestResolvePackageShallowCommit(t *testing.T) {
+	// The in-process server used for local repositories does not support shallow fetches, so the Git binary's
+	// upload-pack is used instead.
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack is not installed")
+	}
+	client.InstallProtocol(fileProtocol, file.DefaultClient)
+	defer InstallLocalTransport()
+
+	for _, allowed := range []bool{true, false} {
+		upstreamDir, _, hashes := newTestRepository(t, 3)
+		if allowed {
+			cmd := exec.Command("git", "config", "uploadpack.allowReachableSHA1InWant", "true")
+			cmd.Dir = upstreamDir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%v: %s", err, out)
+			}
+		}
+
+		f := &ClusterPackagesFilter{
+			CacheDir: t.TempDir(),
+			Logger:   zerolog.Nop(),
+			Shallow:  true,
+		}
+
+		pkg := &Package{Git: kptfile.Git{Repo: upstreamDir, Ref: hashes[1].String()}}
+		hash, repoDir, unlock, err := f.resolvePackage(context.Background(), pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlock()
+		if hash != hashes[1] {
+			t.Fatalf("allowed %t: expected %s, got %s", allowed, hashes[1], hash)
+		}
+
+		// A remote that does not allow commits to be fetched by their SHA falls back to the full repository.
+		expectedDir := f.repositoryDir(upstreamDir)
+		if allowed {
+			expectedDir = f.shallowRepositoryDir(upstreamDir, pkg.Git.Ref)
+		}
+		if repoDir != expectedDir {
+			t.Fatalf("allowed %t: expected %s to be used, got %s", allowed, expectedDir, repoDir)
+		}
+
+		repo, err := git.PlainOpen(repoDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.CommitObject(hashes[0]); (err == plumbing.ErrObjectNotFound) != allowed {
+			t.Fatalf("allowed %t: expected the parent commit to be missing only from a shallow clone, got %v",
+				allowed, err)
+		}
+	}
+}
+
+func TestResolvePackageShallowFallback(t *testing.T) {
+	upstreamDir, _, hashes := newTestRepository(t, 2)
+
+	f := &ClusterPackagesFilter{
+		CacheDir: t.TempDir(),
+		Logger:   zerolog.Nop(),
+		Shallow:  true,
+	}
+
+	// The in-process server used for local repositories does not support shallow fetches, so both branches and commit
+	// SHAs fall back to the full repository.
+	for ref, expected := range map[string]plumbing.Hash{"master": hashes[1], hashes[0].String(): hashes[0]} {
+		pkg := &Package{Git: kptfile.Git{Repo: upstreamDir, Ref: ref}}
+
+		hash, repoDir, unlock, err := f.resolvePackage(context.Background(), pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlock()
+		if repoDir != f.repositoryDir(upstreamDir) {
+			t.Fatalf("%s: expected the full repository to be used, got %s", ref, repoDir)
+		}
+		if hash != expected {
+			t.Fatalf("%s: expected %s, got %s", ref, expected, hash)
+		}
+	}
+}
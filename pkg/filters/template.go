@@ -44,8 +44,9 @@ type TemplateParameters struct {
 
 // TemplateFilter provides a Kyaml filter that processes Kubernetes resources and  renders the scalar node values
 // as Go templates. The function config for this filter specifies Kptfiles whose setters are read to become the
-// template context. On each invocation of the Filter function, SetPackageFilter expects to be given a single Kpt
-// package, where exactly one of the resource nodes pertains to the Kptfile.
+// template context. On each invocation of the Filter function, TemplateFilter expects to be given a single Kpt
+// package, which may contain subpackages. The resources of each subpackage are rendered with the setters of the
+// Kptfile that owns them.
 type TemplateFilter struct{}
 
 // TemplateContext provides the template context that provides all of the
@@ -58,27 +59,21 @@ type loadFn func(leftDelimiter, rightDelimiter string) (template *gotemplate.Tem
 
 // Filter implements Kyaml's yaml.Filter.
 func (f *TemplateFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
-	kptfileNodes, err := KptfileFilter().Filter(nodes)
+	packages, err := groupSubpackages(nodes)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(kptfileNodes) != 1 {
-		return nil, errors.Errorf("expected a single Kptfile in package but got %d", len(kptfileNodes))
-	}
-
-	loadFn := func(leftDelimiter, rightDelimiter string) (template *gotemplate.Template, templateContext *TemplateContext, error error) {
-		return f.load(kptfileNodes[0], leftDelimiter, rightDelimiter)
-	}
-
-	notKptfileNodes, err := NotKptfileFilter().Filter(nodes)
-	if err != nil {
-		return nil, err
-	}
+	for _, p := range packages {
+		p := p
+		loadFn := func(leftDelimiter, rightDelimiter string) (template *gotemplate.Template, templateContext *TemplateContext, error error) {
+			return f.load(p, leftDelimiter, rightDelimiter)
+		}
 
-	for _, node := range notKptfileNodes {
-		if err := f.process(node, loadFn); err != nil {
-			return nil, err
+		for _, node := range p.resources {
+			if err := f.process(node, loadFn); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -156,8 +151,8 @@ func (f *TemplateFilter) render(rn *yaml.RNode, exec executeTemplate, templatePa
 	return nil
 }
 
-func (f *TemplateFilter) load(kptfile *yaml.RNode, leftDelimiter, rightDelimiter string) (*gotemplate.Template, *TemplateContext, error) {
-	templateContext, err := f.loadTemplateContext(kptfile)
+func (f *TemplateFilter) load(p *subpackage, leftDelimiter, rightDelimiter string) (*gotemplate.Template, *TemplateContext, error) {
+	templateContext, err := f.loadTemplateContext(p)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -220,10 +215,14 @@ func (f *TemplateFilter) load(kptfile *yaml.RNode, leftDelimiter, rightDelimiter
 
 // loadTemplateContext reads the Kptfiles specified in the function config and
 // parses all the setter key-value pairs into a cached Go template context object.
-func (f *TemplateFilter) loadTemplateContext(kptfile *yaml.RNode) (*TemplateContext, error) {
+func (f *TemplateFilter) loadTemplateContext(p *subpackage) (*TemplateContext, error) {
 	templateContext := &TemplateContext{Values: map[string]interface{}{}}
 
-	setters, err := f.listSetters(kptfile)
+	if isKptfileV1(p.kptfile) {
+		return f.loadApplySettersContext(p)
+	}
+
+	setters, err := f.listSetters(p.kptfile)
 	if err != nil {
 		return nil, err
 	}
@@ -246,6 +245,34 @@ func (f *TemplateFilter) loadTemplateContext(kptfile *yaml.RNode) (*TemplateCont
 	return templateContext, nil
 }
 
+// loadApplySettersContext returns the template context of the specified subpackage, which has a kpt v1 Kptfile, from
+// the values of the setters in its apply-setters configs. Values that are YAML sequences are loaded as lists.
+func (f *TemplateFilter) loadApplySettersContext(p *subpackage) (*TemplateContext, error) {
+	configs, err := applySettersConfigs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := applySettersValues(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	templateContext := &TemplateContext{Values: map[string]interface{}{}}
+	for name, v := range values {
+		templateContext.Values[name] = v
+
+		var list []string
+		if node, err := yaml.Parse(v); err == nil && node.YNode().Kind == yaml.SequenceNode {
+			if err := node.YNode().Decode(&list); err == nil {
+				templateContext.Values[name] = list
+			}
+		}
+	}
+
+	return templateContext, nil
+}
+
 func (f *TemplateFilter) listSetters(kptfile *yaml.RNode) ([]setters2.SetterDefinition, error) {
 	defs, err := kptfile.Pipe(yaml.Lookup(openapi.SupplementaryOpenAPIFieldName, openapi.Definitions))
 	if err != nil {
@@ -0,0 +1,127 @@
+package filters
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// validateClusterPackages returns a description of every way in which the specified ClusterPackages resources would
+// write resources outside of their own directories: baseDirs and package names that are absolute or traverse out of
+// their parent directory, packages whose directories are the same or nested, and ClusterPackages resources whose
+// baseDirs are the same or nested. ClusterPackages resources with the same name are also reported, as the name
+// identifies them in locks, inventories and the OwnedByAnnotation. Invalid include and exclude file globs, patches that
+// do not specify exactly one of their patch and path, and unknown pipeline functions are also reported.
+func validateClusterPackages(resources []*ClusterPackages) []string {
+	var problems []string
+	var validated []*ClusterPackages
+
+	for _, res := range resources {
+		if res == nil {
+			continue
+		}
+
+		if problem := relativePathProblem(res.Spec.BaseDir, "the output directory"); problem != "" {
+			problems = append(problems, fmt.Sprintf("ClusterPackages %s baseDir %q %s", res.Name, res.Spec.BaseDir, problem))
+		}
+
+		for _, fn := range res.Spec.Pipeline {
+			if !fn.Name.isValid() {
+				problems = append(problems, fmt.Sprintf("ClusterPackages %s pipeline function %q is invalid", res.Name, fn.Name))
+			}
+		}
+
+		for _, other := range validated {
+			if res.Name == other.Name {
+				problems = append(problems, fmt.Sprintf("more than one ClusterPackages resource is named %q", res.Name))
+			}
+			if pathsOverlap(res.Spec.BaseDir, other.Spec.BaseDir) {
+				problem := fmt.Sprintf("ClusterPackages %s baseDir %q overlaps ClusterPackages %s baseDir %q",
+					res.Name, res.Spec.BaseDir, other.Name, other.Spec.BaseDir)
+				if explanation := wholeBaseDirExplanation(res.Spec.BaseDir, other.Spec.BaseDir); explanation != "" {
+					problem += ", as " + explanation
+				}
+				problems = append(problems, problem)
+			}
+		}
+		validated = append(validated, res)
+
+		for i := range res.Spec.Packages {
+			pkg := &res.Spec.Packages[i]
+			if problem := relativePathProblem(pkg.Name, "its baseDir"); problem != "" {
+				problems = append(problems, fmt.Sprintf("ClusterPackages %s package name %q %s", res.Name, pkg.Name, problem))
+			}
+
+			for _, glob := range append(append([]string{}, pkg.Include.Files...), pkg.Exclude.Files...) {
+				if _, err := path.Match(glob, ""); err != nil {
+					problems = append(problems, fmt.Sprintf("ClusterPackages %s package %q file glob %q is invalid",
+						res.Name, pkg.Name, glob))
+				}
+			}
+
+			for j, patch := range pkg.Patches {
+				if (patch.Patch == "") == (patch.Path == "") {
+					problems = append(problems, fmt.Sprintf(
+						"ClusterPackages %s package %q patches[%d] must specify exactly one of patch and path",
+						res.Name, pkg.Name, j))
+				}
+			}
+
+			for _, fn := range pkg.Pipeline {
+				if !fn.Name.isValid() {
+					problems = append(problems, fmt.Sprintf("ClusterPackages %s package %q pipeline function %q is invalid",
+						res.Name, pkg.Name, fn.Name))
+				}
+			}
+
+			for _, other := range res.Spec.Packages[:i] {
+				switch {
+				case pkg.Name == other.Name:
+					problems = append(problems, fmt.Sprintf("ClusterPackages %s has more than one package named %q",
+						res.Name, pkg.Name))
+				case pathsOverlap(pkg.Name, other.Name):
+					problems = append(problems, fmt.Sprintf("ClusterPackages %s package %q overlaps package %q",
+						res.Name, pkg.Name, other.Name))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// relativePathProblem returns a description of why the specified path is not a relative path inside its parent
+// directory, which is described by within, or an empty string if it is.
+func relativePathProblem(p, within string) string {
+	if path.IsAbs(p) || filepath.IsAbs(p) || filepath.VolumeName(p) != "" {
+		return "must be a relative path"
+	}
+
+	if clean := path.Clean(filepath.ToSlash(p)); clean == ".." || strings.HasPrefix(clean, "../") {
+		return "must not be outside " + within
+	}
+
+	return ""
+}
+
+// pathsOverlap returns whether the specified relative paths are the same or one is inside the other.
+func pathsOverlap(a, b string) bool {
+	a, b = path.Clean(filepath.ToSlash(a)), path.Clean(filepath.ToSlash(b))
+	return a == b || isUnderDir(a, b) || isUnderDir(b, a)
+}
+
+// wholeBaseDirExplanation returns an explanation of why the first of the specified baseDirs that is the output
+// directory itself overlaps every other baseDir, or empty if neither of them is.
+func wholeBaseDirExplanation(baseDirs ...string) string {
+	for _, dir := range baseDirs {
+		switch {
+		case dir == "":
+			return "an empty baseDir covers the whole output directory"
+		case path.Clean(filepath.ToSlash(dir)) == ".":
+			return fmt.Sprintf("baseDir %q covers the whole output directory", dir)
+		}
+	}
+
+	return ""
+}
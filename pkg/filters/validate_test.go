@@ -0,0 +1,109 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestValidateClusterPackages(t *testing.T) {
+	newResource := func(name, baseDir string, packages ...string) *ClusterPackages {
+		res := &ClusterPackages{
+			ResourceMeta: yaml.ResourceMeta{ObjectMeta: yaml.ObjectMeta{NameMeta: yaml.NameMeta{Name: name}}},
+			Spec:         ClusterPackagesSpec{BaseDir: baseDir},
+		}
+		for _, p := range packages {
+			res.Spec.Packages = append(res.Spec.Packages, Package{Name: p})
+		}
+		return res
+	}
+
+	var tests = []struct {
+		name      string
+		resources []*ClusterPackages
+		expected  []string
+	}{
+		{
+			name: "valid",
+			resources: []*ClusterPackages{
+				newResource("a", "clusters/a", "app", "team/app", "team/other"),
+				nil,
+				newResource("b", "clusters/b", "app"),
+			},
+		},
+		{
+			name: "path traversal",
+			resources: []*ClusterPackages{
+				newResource("a", "clusters/../../a", "../../other-team", "app/../../app", "app/../app"),
+			},
+			expected: []string{
+				`ClusterPackages a baseDir "clusters/../../a" must not be outside the output directory`,
+				`ClusterPackages a package name "../../other-team" must not be outside its baseDir`,
+				`ClusterPackages a package name "app/../../app" must not be outside its baseDir`,
+			},
+		},
+		{
+			name:      "absolute paths",
+			resources: []*ClusterPackages{newResource("a", "/etc", "/app")},
+			expected: []string{
+				`ClusterPackages a baseDir "/etc" must be a relative path`,
+				`ClusterPackages a package name "/app" must be a relative path`,
+			},
+		},
+		{
+			name:      "duplicate and nested packages",
+			resources: []*ClusterPackages{newResource("a", "clusters/a", "app", "app", "app/nested")},
+			expected: []string{
+				`ClusterPackages a has more than one package named "app"`,
+				`ClusterPackages a package "app/nested" overlaps package "app"`,
+				`ClusterPackages a package "app/nested" overlaps package "app"`,
+			},
+		},
+		{
+			name: "overlapping baseDirs",
+			resources: []*ClusterPackages{
+				newResource("a", "clusters/a"),
+				newResource("b", "clusters/a/"),
+				newResource("c", "clusters"),
+				newResource("d", "clusters/ab"),
+			},
+			expected: []string{
+				`ClusterPackages b baseDir "clusters/a/" overlaps ClusterPackages a baseDir "clusters/a"`,
+				`ClusterPackages c baseDir "clusters" overlaps ClusterPackages a baseDir "clusters/a"`,
+				`ClusterPackages c baseDir "clusters" overlaps ClusterPackages b baseDir "clusters/a/"`,
+				`ClusterPackages d baseDir "clusters/ab" overlaps ClusterPackages c baseDir "clusters"`,
+			},
+		},
+		{
+			name: "whole output directory baseDirs",
+			resources: []*ClusterPackages{
+				newResource("a", ""),
+				newResource("b", ""),
+				newResource("c", "./"),
+			},
+			expected: []string{
+				`ClusterPackages b baseDir "" overlaps ClusterPackages a baseDir "", as an empty baseDir covers the whole output directory`,
+				`ClusterPackages c baseDir "./" overlaps ClusterPackages a baseDir "", as baseDir "./" covers the whole output directory`,
+				`ClusterPackages c baseDir "./" overlaps ClusterPackages b baseDir "", as baseDir "./" covers the whole output directory`,
+			},
+		},
+		{
+			name: "duplicate names",
+			resources: []*ClusterPackages{
+				newResource("cluster", "clusters/a"),
+				newResource("cluster", "clusters/b"),
+			},
+			expected: []string{`more than one ClusterPackages resource is named "cluster"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := validateClusterPackages(test.resources)
+			if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(test.expected, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
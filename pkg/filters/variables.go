@@ -0,0 +1,370 @@
+package filters
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-openapi/spec"
+	"sigs.k8s.io/kustomize/kyaml/errors"
+	"sigs.k8s.io/kustomize/kyaml/fieldmeta"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	"sigs.k8s.io/kustomize/kyaml/setters2"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// variableProblems is returned by fetchClusterResources when the variables of a package do not validate against the
+// setters of its Kptfiles, or when its required setters are not set, so that the problems of every package can be
+// reported together.
+type variableProblems []string
+
+// Error implements error.
+func (p variableProblems) Error() string {
+	return problemsError("invalid variables", p).Error()
+}
+
+// scopedVariable is a variable along with the level at which it is defined.
+type scopedVariable struct {
+	Variable
+	// scope is either cluster or package.
+	scope string
+}
+
+// packageVariables returns the variables that are applied to the specified package of the specified ClusterPackages
+// resource, excluding cluster-level variables that are overridden by package-level variables.
+func packageVariables(res *ClusterPackages, pkg *Package) []scopedVariable {
+	overridden := map[string]bool{}
+	for _, v := range pkg.Variables {
+		overridden[v.Name] = true
+	}
+
+	var variables []scopedVariable
+	for _, v := range res.Spec.Variables {
+		if !overridden[v.Name] {
+			variables = append(variables, scopedVariable{Variable: v, scope: "cluster"})
+		}
+	}
+	for _, v := range pkg.Variables {
+		variables = append(variables, scopedVariable{Variable: v, scope: "package"})
+	}
+
+	return variables
+}
+
+// validateVariables returns a description of every way in which the variables of the specified package of the
+// specified ClusterPackages resource do not validate against the definitions of the setters with the same names in
+// the kpt v1alpha1 Kptfiles of the package and its subpackages, which are read from the specified nodes. Setters of
+// kpt v1 Kptfiles have no schema and are not validated.
+func validateVariables(res *ClusterPackages, pkg *Package, nodes []*yaml.RNode) ([]string, error) {
+	packages, err := groupSubpackages(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, p := range packages {
+		if isKptfileV1(p.kptfile) {
+			continue
+		}
+
+		name := pkg.Name
+		if p.dir != "." {
+			name = path.Join(pkg.Name, p.dir)
+		}
+
+		for _, v := range packageVariables(res, pkg) {
+			schema, setter, err := setterSchema(p.kptfile, v.Name)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not read setter %s of package %s", v.Name, name)
+			}
+			if setter == nil {
+				continue
+			}
+
+			for _, problem := range validateVariable(v.Variable, schema, setter) {
+				problems = append(problems, fmt.Sprintf("package %s %s variable %s %s", name, v.scope, v.Name, problem))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// setterSchema returns the OpenAPI schema and the definition of the setter with the specified name in the specified
+// resource node (that is assumed to pertain to a kpt v1alpha1 Kptfile), or nils if it does not contain the setter.
+func setterSchema(kptfile *yaml.RNode, name string) (*spec.Schema, *setters2.SetterDefinition, error) {
+	setter, err := kptfileSetter(kptfile, name)
+	if err != nil || setter == nil {
+		return nil, nil, err
+	}
+
+	def, err := kptfile.Pipe(yaml.Lookup(openapi.SupplementaryOpenAPIFieldName, openapi.Definitions,
+		fieldmeta.SetterDefinitionPrefix+name))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var o interface{}
+	if err := def.Document().Decode(&o); err != nil {
+		return nil, nil, err
+	}
+	j, err := json.Marshal(o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var schema spec.Schema
+	if err := schema.UnmarshalJSON(j); err != nil {
+		return nil, nil, err
+	}
+
+	return &schema, setter, nil
+}
+
+// validateVariable returns a description of every way in which the value of the specified variable does not validate
+// against the specified setter schema and definition.
+func validateVariable(v Variable, schema *spec.Schema, setter *setters2.SetterDefinition) []string {
+	isList := schema.Type.Contains("array") || len(setter.ListValues) > 0
+	if !isList {
+		if len(v.ListValues) > 0 {
+			return []string{fmt.Sprintf("value [%s] is a list but setter %s is not a list",
+				strings.Join(v.ListValues, ", "), setter.Name)}
+		}
+		return validateValue(v.Value, schema, setter)
+	}
+
+	// A single value sets a list setter to a list of that value.
+	values := v.ListValues
+	if len(values) == 0 {
+		values = []string{v.Value}
+	}
+
+	var problems []string
+	list := strings.Join(values, ", ")
+	if schema.MinItems != nil && int64(len(values)) < *schema.MinItems {
+		problems = append(problems, fmt.Sprintf("value [%s] must have at least %d items", list, *schema.MinItems))
+	}
+	if schema.MaxItems != nil && int64(len(values)) > *schema.MaxItems {
+		problems = append(problems, fmt.Sprintf("value [%s] must have at most %d items", list, *schema.MaxItems))
+	}
+
+	itemSchema := &spec.Schema{}
+	if schema.Items != nil && schema.Items.Schema != nil {
+		itemSchema = schema.Items.Schema
+	}
+	for i, value := range values {
+		for _, problem := range validateValue(value, itemSchema, setter) {
+			problems = append(problems, fmt.Sprintf("item %d %s", i, problem))
+		}
+	}
+
+	return problems
+}
+
+// validateValue returns a description of every way in which the specified scalar value does not validate against the
+// specified schema and the enumValues of the specified setter definition. Setters with enumValues map the value to
+// the value that is set, so only membership of the enumValues is validated for them.
+func validateValue(value string, schema *spec.Schema, setter *setters2.SetterDefinition) []string {
+	var problems []string
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("value %q ", value)+fmt.Sprintf(format, args...))
+	}
+
+	if len(setter.EnumValues) > 0 {
+		if _, ok := setter.EnumValues[value]; !ok {
+			var keys []string
+			for k := range setter.EnumValues {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			invalid("must be one of [%s]", strings.Join(keys, ", "))
+		}
+		return problems
+	}
+
+	if len(schema.Enum) > 0 {
+		var match bool
+		var values []string
+		for _, e := range schema.Enum {
+			values = append(values, fmt.Sprint(e))
+			match = match || fmt.Sprint(e) == value
+		}
+		if !match {
+			invalid("must be one of [%s]", strings.Join(values, ", "))
+		}
+	}
+
+	var number *float64
+	switch {
+	case schema.Type.Contains("string") || len(schema.Type) == 0:
+	case schema.Type.Contains("integer"):
+		if i, err := strconv.ParseInt(value, 10, 64); err != nil {
+			invalid("must be an integer")
+		} else {
+			f := float64(i)
+			number = &f
+		}
+	case schema.Type.Contains("number"):
+		if f, err := strconv.ParseFloat(value, 64); err != nil {
+			invalid("must be a number")
+		} else {
+			number = &f
+		}
+	case schema.Type.Contains("boolean"):
+		if v := strings.ToLower(value); v != "true" && v != "false" {
+			invalid("must be a boolean")
+		}
+	}
+
+	if number != nil {
+		if min := schema.Minimum; min != nil && (*number < *min || schema.ExclusiveMinimum && *number == *min) {
+			if schema.ExclusiveMinimum {
+				invalid("must be greater than %v", *min)
+			} else {
+				invalid("must be at least %v", *min)
+			}
+		}
+		if max := schema.Maximum; max != nil && (*number > *max || schema.ExclusiveMaximum && *number == *max) {
+			if schema.ExclusiveMaximum {
+				invalid("must be less than %v", *max)
+			} else {
+				invalid("must be at most %v", *max)
+			}
+		}
+	}
+
+	if schema.MinLength != nil && int64(utf8.RuneCountInString(value)) < *schema.MinLength {
+		invalid("must be at least %d characters long", *schema.MinLength)
+	}
+	if schema.MaxLength != nil && int64(utf8.RuneCountInString(value)) > *schema.MaxLength {
+		invalid("must be at most %d characters long", *schema.MaxLength)
+	}
+
+	if schema.Pattern != "" {
+		if pattern, err := regexp.Compile(schema.Pattern); err != nil {
+			invalid("cannot be validated as the pattern %s of setter %s is invalid", schema.Pattern, setter.Name)
+		} else if !pattern.MatchString(value) {
+			invalid("must match pattern %s", schema.Pattern)
+		}
+	}
+
+	return problems
+}
+
+// unknownVariables returns the names of the package-level variables of the specified package that do not match any
+// setter of the Kptfiles of the package and its subpackages, which are read from the specified nodes. Cluster-level
+// variables are not returned, as they are applied to every package and are not expected to match the setters of all
+// of them.
+func unknownVariables(pkg *Package, nodes []*yaml.RNode) ([]string, error) {
+	packages, err := groupSubpackages(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, p := range packages {
+		names, err := subpackageSetterNames(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			known[name] = true
+		}
+	}
+
+	var unknown []string
+	for _, v := range pkg.Variables {
+		if !known[v.Name] {
+			unknown = append(unknown, v.Name)
+		}
+	}
+
+	return unknown, nil
+}
+
+// subpackageSetterNames returns the names of the setters of the specified subpackage, which are either defined by
+// its kpt v1alpha1 Kptfile or declared by the apply-setters configs of its kpt v1 Kptfile.
+func subpackageSetterNames(p *subpackage) ([]string, error) {
+	if !isKptfileV1(p.kptfile) {
+		return setterNames(p.kptfile)
+	}
+
+	configs, err := applySettersConfigs(p)
+	if err != nil {
+		return nil, err
+	}
+	values, err := applySettersValues(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// unsetRequiredSetters returns a description of every setter of the kpt v1alpha1 Kptfiles of the specified package
+// and its subpackages, which are read from the specified nodes, that is marked as required but has not been set.
+// Setters of kpt v1 Kptfiles cannot be marked as required and are not checked.
+func unsetRequiredSetters(pkg *Package, nodes []*yaml.RNode) ([]string, error) {
+	packages, err := groupSubpackages(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, p := range packages {
+		if isKptfileV1(p.kptfile) {
+			continue
+		}
+
+		name := pkg.Name
+		if p.dir != "." {
+			name = path.Join(pkg.Name, p.dir)
+		}
+
+		setters, err := setterNames(p.kptfile)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(setters)
+
+		for _, s := range setters {
+			setter, err := kptfileSetter(p.kptfile, s)
+			if err != nil {
+				return nil, errors.WrapPrefixf(err, "could not read setter %s of package %s", s, name)
+			}
+			if setter != nil && setter.Required && !setter.IsSet {
+				problems = append(problems, fmt.Sprintf("package %s setter %s is required but is not set", name, s))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// requiredSettersFilter returns a kio.Filter that fails with the variableProblems of the specified package if any of
+// its required setters have not been set.
+func requiredSettersFilter(pkg *Package) kio.Filter {
+	return kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+		problems, err := unsetRequiredSetters(pkg, nodes)
+		if err != nil {
+			return nil, err
+		}
+		if len(problems) > 0 {
+			return nil, variableProblems(problems)
+		}
+
+		return nodes, nil
+	})
+}
@@ -0,0 +1,136 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/setters2"
+)
+
+func TestValidateVariable(t *testing.T) {
+	float := func(f float64) *float64 { return &f }
+	integer := func(i int64) *int64 { return &i }
+
+	var tests = []struct {
+		name     string
+		variable Variable
+		schema   spec.Schema
+		setter   setters2.SetterDefinition
+		expected []string
+	}{
+		{
+			name:     "untyped",
+			variable: Variable{Value: "anything"},
+		},
+		{
+			name:     "number",
+			variable: Variable{Value: "1.5"},
+			schema:   spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"number"}, Maximum: float(1)}},
+			expected: []string{`value "1.5" must be at most 1`},
+		},
+		{
+			name:     "exclusive minimum",
+			variable: Variable{Value: "0"},
+			schema: spec.Schema{SchemaProps: spec.SchemaProps{
+				Type: spec.StringOrArray{"integer"}, Minimum: float(0), ExclusiveMinimum: true,
+			}},
+			expected: []string{`value "0" must be greater than 0`},
+		},
+		{
+			name:     "boolean",
+			variable: Variable{Value: "yes"},
+			schema:   spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"boolean"}}},
+			expected: []string{`value "yes" must be a boolean`},
+		},
+		{
+			name:     "length",
+			variable: Variable{Value: "ab"},
+			schema:   spec.Schema{SchemaProps: spec.SchemaProps{MinLength: integer(3)}},
+			expected: []string{`value "ab" must be at least 3 characters long`},
+		},
+		{
+			name:     "enum values",
+			variable: Variable{Value: "small"},
+			schema:   spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"integer"}}},
+			setter:   setters2.SetterDefinition{EnumValues: map[string]string{"small": "1"}},
+		},
+		{
+			name:     "single value for list",
+			variable: Variable{Value: "a"},
+			schema: spec.Schema{SchemaProps: spec.SchemaProps{
+				Type: spec.StringOrArray{"array"}, MinItems: integer(2),
+			}},
+			expected: []string{`value [a] must have at least 2 items`},
+		},
+		{
+			name:     "list items",
+			variable: Variable{ListValues: []string{"1", "x"}},
+			schema: spec.Schema{SchemaProps: spec.SchemaProps{
+				Type:  spec.StringOrArray{"array"},
+				Items: &spec.SchemaOrArray{Schema: &spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"integer"}}}},
+			}},
+			expected: []string{`item 1 value "x" must be an integer`},
+		},
+		{
+			name:     "list for scalar",
+			variable: Variable{ListValues: []string{"a"}},
+			setter:   setters2.SetterDefinition{Name: "name"},
+			expected: []string{`value [a] is a list but setter name is not a list`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := validateVariable(test.variable, &test.schema, &test.setter)
+			if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(test.expected, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
+
+func TestUnknownVariables(t *testing.T) {
+	const input = `apiVersion: kpt.dev/v1alpha1
+kind: Kptfile
+metadata:
+  name: root
+  annotations:
+    config.kubernetes.io/path: Kptfile
+openAPI:
+  definitions:
+    io.k8s.cli.setters.replicas:
+      x-k8s-cli:
+        setter:
+          name: replicas
+          value: "1"
+---
+apiVersion: kpt.dev/v1
+kind: Kptfile
+metadata:
+  name: child
+  annotations:
+    config.kubernetes.io/path: child/Kptfile
+pipeline:
+  mutators:
+    - image: gcr.io/kpt-fn/apply-setters:v0.1
+      configMap:
+        image: nginx
+`
+
+	nodes, err := (&kio.ByteReader{Reader: strings.NewReader(input), OmitReaderAnnotations: true}).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &Package{Variables: []Variable{{Name: "replicas"}, {Name: "image"}, {Name: "replica"}, {Name: "images"}}}
+	actual, err := unknownVariables(pkg, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "replica,images"; strings.Join(actual, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(actual, ","))
+	}
+}